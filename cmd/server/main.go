@@ -3,17 +3,35 @@ package main
 import (
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/adgundersen/crimata-infra/internal/api"
+	"github.com/adgundersen/crimata-infra/internal/audit"
+	"github.com/adgundersen/crimata-infra/internal/cert"
 	"github.com/adgundersen/crimata-infra/internal/compute"
 	"github.com/adgundersen/crimata-infra/internal/dns"
+	"github.com/adgundersen/crimata-infra/internal/domain"
 	"github.com/adgundersen/crimata-infra/internal/export"
+	"github.com/adgundersen/crimata-infra/internal/health"
+	"github.com/adgundersen/crimata-infra/internal/image"
 	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/metrics"
+	"github.com/adgundersen/crimata-infra/internal/migration"
 	"github.com/adgundersen/crimata-infra/internal/notify"
+	"github.com/adgundersen/crimata-infra/internal/plan"
+	"github.com/adgundersen/crimata-infra/internal/pool"
+	"github.com/adgundersen/crimata-infra/internal/profile"
+	"github.com/adgundersen/crimata-infra/internal/remediation"
+	"github.com/adgundersen/crimata-infra/internal/snapshot"
+	"github.com/adgundersen/crimata-infra/internal/sshca"
+	"github.com/adgundersen/crimata-infra/internal/upgrade"
 	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	_ "github.com/lib/pq"
 )
@@ -30,6 +48,90 @@ func main() {
 		log.Fatalf("migrate: %v", err)
 	}
 
+	exports := export.NewStore(db)
+	if err := exports.Migrate(); err != nil {
+		log.Fatalf("migrate exports: %v", err)
+	}
+
+	// The default plan mirrors EC2_INSTANCE_TYPE until plans are managed via /plans
+	defaultPlan := getEnv("DEFAULT_PLAN", "standard")
+	plans := plan.NewStore(db)
+	if err := plans.Migrate(); err != nil {
+		log.Fatalf("migrate plans: %v", err)
+	}
+	if err := plans.Seed(&plan.Plan{
+		Name:         defaultPlan,
+		InstanceType: getEnv("EC2_INSTANCE_TYPE", "t3.micro"),
+		Features:     []string{},
+	}); err != nil {
+		log.Fatalf("seed plans: %v", err)
+	}
+
+	certs := cert.NewStore(db)
+	if err := certs.Migrate(); err != nil {
+		log.Fatalf("migrate certificates: %v", err)
+	}
+
+	domains := domain.NewStore(db)
+	if err := domains.Migrate(); err != nil {
+		log.Fatalf("migrate domains: %v", err)
+	}
+
+	images := image.NewStore(db)
+	if err := images.Migrate(); err != nil {
+		log.Fatalf("migrate images: %v", err)
+	}
+
+	warmPool := pool.NewStore(db)
+	if err := warmPool.Migrate(); err != nil {
+		log.Fatalf("migrate pool: %v", err)
+	}
+
+	// The embedded provision.sh seeds version 1 of the default profile
+	provisionProfile := getEnv("PROVISION_PROFILE", "crimata-os")
+	profiles := profile.NewStore(db)
+	if err := profiles.Migrate(); err != nil {
+		log.Fatalf("migrate profiles: %v", err)
+	}
+	if err := profiles.Seed(provisionProfile, profile.DefaultScript); err != nil {
+		log.Fatalf("seed profiles: %v", err)
+	}
+
+	upgrades := upgrade.NewStore(db)
+	if err := upgrades.Migrate(); err != nil {
+		log.Fatalf("migrate upgrades: %v", err)
+	}
+
+	healthChecks := health.NewStore(db)
+	if err := healthChecks.Migrate(); err != nil {
+		log.Fatalf("migrate health checks: %v", err)
+	}
+
+	remediations := remediation.NewStore(db)
+	if err := remediations.Migrate(); err != nil {
+		log.Fatalf("migrate remediations: %v", err)
+	}
+
+	auditLog := audit.NewStore(db)
+	if err := auditLog.Migrate(); err != nil {
+		log.Fatalf("migrate audit log: %v", err)
+	}
+
+	metricStore := metrics.NewStore(db)
+	if err := metricStore.Migrate(); err != nil {
+		log.Fatalf("migrate metrics: %v", err)
+	}
+
+	snapshots := snapshot.NewStore(db)
+	if err := snapshots.Migrate(); err != nil {
+		log.Fatalf("migrate snapshots: %v", err)
+	}
+
+	migrations := migration.NewStore(db)
+	if err := migrations.Migrate(); err != nil {
+		log.Fatalf("migrate migrations: %v", err)
+	}
+
 	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(),
 		awsconfig.WithRegion(mustEnv("AWS_REGION")),
 	)
@@ -37,16 +139,43 @@ func main() {
 		log.Fatalf("load aws config: %v", err)
 	}
 
+	// Operator certificates are only available with a CA key
+	var ca *sshca.CA
+	var caPublicKey string
+	if keyFile := os.Getenv("SSH_CA_KEY"); keyFile != "" {
+		if ca, err = sshca.Load(sshca.Config{
+			KeyFile:    keyFile,
+			DefaultTTL: getDuration("SSH_CERT_TTL", 15*time.Minute),
+			MaxTTL:     getDuration("SSH_CERT_MAX_TTL", time.Hour),
+		}); err != nil {
+			log.Fatalf("load ssh ca: %v", err)
+		}
+		caPublicKey = ca.PublicKey()
+	}
+
 	computeClient := compute.NewClient(awsCfg, compute.Config{
 		AMI:             mustEnv("EC2_AMI"),
 		InstanceType:    getEnv("EC2_INSTANCE_TYPE", "t3.micro"),
 		SecurityGroupID: mustEnv("EC2_SECURITY_GROUP"),
 		SubnetID:        mustEnv("EC2_SUBNET"),
+		ElasticIP:       getEnv("EC2_ELASTIC_IP", "false") == "true",
+		IPv6:            getEnv("EC2_IPV6", "false") == "true",
+		IAMProfile:      os.Getenv("EC2_IAM_PROFILE"),
+		Executor:        getEnv("EXECUTOR", "ssh"),
+		SSHCAPublicKey:  caPublicKey,
+		Regions:         regionConfigs(getList("EC2_REGIONS")),
 	})
 
+	if len(os.Args) > 1 && os.Args[1] == "build-image" {
+		buildImage(computeClient, images, os.Args[2:])
+		return
+	}
+
 	dnsClient := dns.NewClient(awsCfg, dns.Config{
 		HostedZoneID: mustEnv("HOSTED_ZONE_ID"),
 		BaseDomain:   getEnv("BASE_DOMAIN", "crimata.com"),
+		SuspendedIP:  os.Getenv("DNS_SUSPENDED_IP"),
+		Resolver:     os.Getenv("DNS_VERIFY_RESOLVER"),
 	})
 
 	notifyClient := notify.NewClient(notify.Config{
@@ -56,17 +185,116 @@ func main() {
 	})
 
 	exportClient := export.NewClient(awsCfg, export.Config{
-		S3Bucket: mustEnv("S3_EXPORT_BUCKET"),
-		Region:   mustEnv("AWS_REGION"),
+		S3Bucket:   mustEnv("S3_EXPORT_BUCKET"),
+		Region:     mustEnv("AWS_REGION"),
+		Endpoint:   os.Getenv("S3_ENDPOINT"),
+		LinkExpiry: getDuration("EXPORT_LINK_EXPIRY", 24*time.Hour),
 	})
 
-	handler := api.NewHandler(store, computeClient, dnsClient, notifyClient, exportClient)
+	acmeClient := cert.NewClient(certs, cert.Config{
+		DirectoryURL: os.Getenv("ACME_DIRECTORY_URL"),
+		Email:        os.Getenv("ACME_EMAIL"),
+	})
+
+	// Samples are per period; CloudWatch's basic EC2 monitoring is 5 minutes
+	metricsPeriod := getDuration("METRICS_PERIOD", 5*time.Minute)
+	var metricSource metrics.Source = metrics.NewCloudWatch(awsCfg, metricsPeriod)
+	fakeMetrics := getEnv("METRICS_SOURCE", "cloudwatch") == "fake"
+	if fakeMetrics {
+		fake := &metrics.Fake{Period: metricsPeriod}
+		if disk := getInt("METRICS_FAKE_DISK", 0); disk > 0 {
+			fake.Set(metrics.Disk, float64(disk))
+		}
+		metricSource = fake
+	}
+
+	handler := api.NewHandler(api.Config{
+		GracePeriod:     getDuration("DELETION_GRACE_PERIOD", 14*24*time.Hour),
+		DefaultPlan:     defaultPlan,
+		CertRenewBefore: getDuration("CERT_RENEW_BEFORE", 30*24*time.Hour),
+		Profile:         provisionProfile,
+
+		HealthFailureThreshold: getInt("HEALTH_FAILURE_THRESHOLD", 3),
+		HealthRetention:        getDuration("HEALTH_RETENTION", 7*24*time.Hour),
+		AutoRemediate:          getEnv("REMEDIATION_AUTO", "true") == "true",
+		ExecFreeForm:           getEnv("EXEC_FREE_FORM", "false") == "true",
+		DiskAlertPercent:       float64(getInt("DISK_ALERT_PERCENT", 85)),
+		MetricsRetention:       getDuration("METRICS_RETENTION", 30*24*time.Hour),
+		SnapshotEvery:          getDuration("SNAPSHOT_EVERY", 24*time.Hour),
+		SnapshotRetention:      getDuration("SNAPSHOT_RETENTION", 14*24*time.Hour),
+		SnapshotKeep:           getInt("SNAPSHOT_KEEP", 3),
+	}, store, exports, plans, certs, domains, images, warmPool, profiles, upgrades,
+		healthChecks, health.NewProber(getList("HEALTH_PATHS"), getDuration("HEALTH_TIMEOUT", 10*time.Second)),
+		remediations, auditLog, metricStore, snapshots, migrations, metricSource, ca, computeClient, dnsClient, notifyClient, exportClient, acmeClient)
+
+	if err := handler.RecoverUpgrades(); err != nil {
+		log.Fatalf("recover upgrades: %v", err)
+	}
+	if err := handler.RecoverKeyRotations(); err != nil {
+		log.Fatalf("recover key rotations: %v", err)
+	}
+	if err := handler.RecoverMigrations(); err != nil {
+		log.Fatalf("recover migrations: %v", err)
+	}
+	if err := handler.RecoverRestores(); err != nil {
+		log.Fatalf("recover restores: %v", err)
+	}
+
+	go handler.RunDeletionScheduler(context.Background(), getDuration("SCHEDULER_INTERVAL", 15*time.Minute))
+	go handler.RunCertRenewal(context.Background(), getDuration("CERT_RENEWAL_INTERVAL", 12*time.Hour))
+	go handler.RunDomainVerifier(context.Background(), getDuration("DOMAIN_VERIFY_INTERVAL", 5*time.Minute))
+	go handler.RunHealthProber(context.Background(), getDuration("HEALTH_INTERVAL", time.Minute))
+	go handler.RunSnapshotScheduler(context.Background(), getDuration("SNAPSHOT_INTERVAL", 15*time.Minute))
+	go handler.RunMetricsCollector(context.Background(), getDuration("METRICS_INTERVAL", 5*time.Minute))
+	go handler.RunSSHCATrust(context.Background(), getDuration("SSH_CA_SYNC_INTERVAL", time.Hour))
+	if !fakeMetrics {
+		go handler.RunMetricsAgentRollout(context.Background(), getDuration("METRICS_AGENT_SYNC_INTERVAL", time.Hour))
+	}
+	go handler.RunPoolReplenisher(context.Background(), getDuration("POOL_REPLENISH_INTERVAL", time.Minute))
+	if maxAge := getDuration("SSH_KEY_MAX_AGE", 90*24*time.Hour); maxAge > 0 {
+		go handler.RunKeyRotation(context.Background(), getDuration("SSH_KEY_ROTATION_INTERVAL", time.Hour), maxAge)
+	}
 
 	port := getEnv("PORT", "9000")
 	fmt.Printf("crimata-infra listening on :%s\n", port)
 	log.Fatal(http.ListenAndServe(":"+port, handler.Routes()))
 }
 
+// buildImage bakes and records a new golden AMI, then exits:
+//
+//	infra build-image [-version v] [-timeout d]
+func buildImage(computeClient *compute.Client, images *image.Store, args []string) {
+	fs := flag.NewFlagSet("build-image", flag.ExitOnError)
+	version := fs.String("version", time.Now().UTC().Format("20060102-150405"), "image version")
+	timeout := fs.Duration("timeout", time.Hour, "give up after this long")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	img, err := image.Build(ctx, computeClient, images, *version)
+	if err != nil {
+		log.Fatalf("build image: %v", err)
+	}
+	fmt.Printf("image %s built: %s\n", img.Version, img.AMIID)
+}
+
+// regionConfigs reads each extra region's launch settings from
+// EC2_AMI_<REGION>, EC2_SECURITY_GROUP_<REGION> and EC2_SUBNET_<REGION>,
+// e.g. EC2_SUBNET_EU_WEST_1.
+func regionConfigs(regions []string) map[string]compute.RegionConfig {
+	configs := make(map[string]compute.RegionConfig, len(regions))
+	for _, region := range regions {
+		suffix := strings.ToUpper(strings.ReplaceAll(region, "-", "_"))
+		configs[region] = compute.RegionConfig{
+			AMI:             mustEnv("EC2_AMI_" + suffix),
+			SecurityGroupID: mustEnv("EC2_SECURITY_GROUP_" + suffix),
+			SubnetID:        mustEnv("EC2_SUBNET_" + suffix),
+		}
+	}
+	return configs
+}
+
 func mustEnv(key string) string {
 	v := os.Getenv(key)
 	if v == "" {
@@ -81,3 +309,42 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid duration for %s: %v", key, err)
+	}
+	return d
+}
+
+func getInt(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid integer for %s: %v", key, err)
+	}
+	return n
+}
+
+// getList splits a comma-separated env var, returning nil if it is unset.
+func getList(key string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/health"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/remediation"
+	"github.com/go-chi/chi/v5"
+)
+
+type actionRequest struct {
+	Unit string `json:"unit"` // restart-service only
+}
+
+// actionOutputLimit caps the command output kept per run.
+const actionOutputLimit = 16 << 10
+
+func (h *Handler) runAction(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	name := chi.URLParam(r, "action")
+	if _, ok := remediation.Actions[name]; !ok {
+		http.Error(w, "unknown action", http.StatusNotFound)
+		return
+	}
+
+	var req actionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	if name == remediation.RestartService && !remediation.ValidUnit(req.Unit) {
+		http.Error(w, "unit must be one of the Crimata services", http.StatusBadRequest)
+		return
+	}
+	if inst.Status != instance.StatusActive && inst.Status != instance.StatusDegraded {
+		http.Error(w, fmt.Sprintf("cannot run actions on instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	run := &remediation.Run{InstanceID: inst.ID, Action: name, Unit: req.Unit, Trigger: "manual"}
+	switch err := h.remediations.Start(run); {
+	case errors.Is(err, remediation.ErrBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, remediation.ErrRateLimited):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, "failed to start action", http.StatusInternalServerError)
+		return
+	}
+
+	// Encoded before remediate starts filling in the outcome
+	jsonResponse(w, run, http.StatusAccepted)
+	go h.remediate(context.Background(), inst, run)
+}
+
+func (h *Handler) listActions(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	runs, err := h.remediations.ListByInstance(inst.ID, 50)
+	if err != nil {
+		http.Error(w, "failed to list actions", http.StatusInternalServerError)
+		return
+	}
+	if runs == nil {
+		runs = []*remediation.Run{}
+	}
+	jsonResponse(w, runs, http.StatusOK)
+}
+
+// ── Execution ─────────────────────────────────────────────────────────────────
+
+// remediate executes a started run, then waits for the hub to pass its
+// health checks; a run only succeeds if it does.
+func (h *Handler) remediate(ctx context.Context, inst *instance.Instance, run *remediation.Run) {
+	fmt.Printf("remediation: %s %s on %s (%s)\n", run.Action, run.Unit, inst.Slug, run.Trigger)
+
+	out, err := h.executeAction(ctx, inst, run)
+	run.Output = tail(out, actionOutputLimit)
+	if err == nil {
+		wait := time.Minute
+		if run.Action == remediation.Reboot {
+			wait = 5 * time.Minute
+		}
+		err = h.waitHealthy(ctx, inst, wait)
+	}
+
+	run.Status = remediation.StatusSucceeded
+	if err != nil {
+		run.Status, run.Error = remediation.StatusFailed, err.Error()
+		fmt.Printf("remediation: %s on %s failed: %v\n", run.Action, inst.Slug, err)
+	}
+	if err := h.remediations.Finish(run); err != nil {
+		fmt.Printf("remediation: failed to record run %d: %v\n", run.ID, err)
+	}
+}
+
+func (h *Handler) executeAction(ctx context.Context, inst *instance.Instance, run *remediation.Run) (string, error) {
+	switch run.Action {
+	case remediation.RestartService:
+		return h.compute.Run(ctx, target(inst), fmt.Sprintf("set -e\nsystemctl restart %[1]s\nsystemctl is-active %[1]s\n", run.Unit))
+
+	case remediation.RestartNginx:
+		return h.compute.Run(ctx, target(inst), "set -e\nnginx -t\nsystemctl restart nginx\n")
+
+	case remediation.Reboot:
+		if err := h.computeFor(inst).Reboot(ctx, inst.EC2InstanceID); err != nil {
+			return "", err
+		}
+		// Give the instance time to go down before health checks start
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(30 * time.Second):
+		}
+		return "", nil
+
+	case remediation.Reprovision:
+		prof, err := h.profiles.Get(inst.Profile, inst.ProfileVersion)
+		if err == nil && prof == nil {
+			prof, err = h.profiles.Pick(h.cfg.Profile, inst.Slug)
+		}
+		if err != nil || prof == nil {
+			return "", fmt.Errorf("no provisioning profile for %s: %v", inst.Slug, err)
+		}
+		// Older scripts set the password unconditionally, blanking it
+		if !prof.KeepsPassword {
+			return "", fmt.Errorf("profile %s v%d does not keep the customer's password; publish a version with keeps_password", prof.Name, prof.Version)
+		}
+		// An empty password keeps the customer's; the database password is
+		// rotated because the original isn't stored
+		err = h.compute.Provision(ctx, target(inst).Host, inst.SSHPrivateKey, compute.ProvisionSpec{
+			Script: prof.Script,
+			Params: prof.Params,
+			Args:   []string{inst.Slug, "", randomHex(24)},
+			Baked:  true,
+		})
+		if err != nil {
+			return "", err
+		}
+		// nginx's TLS and custom domain includes survive the rewrite
+		if prof.Name != inst.Profile || prof.Version != inst.ProfileVersion {
+			h.store.UpdateProfile(inst.ID, prof.Name, prof.Version)
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown action %q", run.Action)
+}
+
+// autoRemediate picks an action for a degraded hub from its failing checks:
+// a reboot if nothing answers at all, nginx if the root fails, otherwise the
+// units behind the failing endpoints. An action over its rate limit
+// escalates to a reboot, which has its own, stricter limit.
+func (h *Handler) autoRemediate(ctx context.Context, inst *instance.Instance, checks []health.Check) {
+	var runs []*remediation.Run
+	unreachable := true
+	for _, c := range checks {
+		if c.OK || c.StatusCode != 0 {
+			unreachable = false
+		}
+	}
+
+	switch {
+	case unreachable:
+		runs = append(runs, &remediation.Run{Action: remediation.Reboot})
+	default:
+		for _, c := range checks {
+			if c.OK {
+				continue
+			}
+			if c.Path == "/" {
+				runs = []*remediation.Run{{Action: remediation.RestartNginx}}
+				break
+			}
+			if unit, ok := remediation.PathUnits[c.Path]; ok {
+				runs = append(runs, &remediation.Run{Action: remediation.RestartService, Unit: unit})
+			}
+		}
+	}
+
+	for _, run := range runs {
+		run.InstanceID, run.Trigger = inst.ID, "health"
+		err := h.remediations.Start(run)
+		if errors.Is(err, remediation.ErrRateLimited) && run.Action != remediation.Reboot {
+			fmt.Printf("remediation: %s %s rate limited on %s, escalating to reboot\n", run.Action, run.Unit, inst.Slug)
+			run = &remediation.Run{InstanceID: inst.ID, Action: remediation.Reboot, Trigger: "health"}
+			err = h.remediations.Start(run)
+		}
+		if err != nil {
+			if !errors.Is(err, remediation.ErrBusy) {
+				fmt.Printf("remediation: %s skipped on %s: %v\n", run.Action, inst.Slug, err)
+			}
+			return
+		}
+		h.remediate(ctx, inst, run)
+		if run.Status == remediation.StatusSucceeded {
+			return
+		}
+	}
+}
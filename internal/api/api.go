@@ -9,31 +9,151 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/adgundersen/crimata-infra/internal/audit"
+	"github.com/adgundersen/crimata-infra/internal/cert"
 	"github.com/adgundersen/crimata-infra/internal/compute"
 	"github.com/adgundersen/crimata-infra/internal/dns"
+	"github.com/adgundersen/crimata-infra/internal/domain"
 	"github.com/adgundersen/crimata-infra/internal/export"
+	"github.com/adgundersen/crimata-infra/internal/health"
+	"github.com/adgundersen/crimata-infra/internal/image"
 	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/metrics"
+	"github.com/adgundersen/crimata-infra/internal/migration"
 	"github.com/adgundersen/crimata-infra/internal/notify"
+	"github.com/adgundersen/crimata-infra/internal/plan"
+	"github.com/adgundersen/crimata-infra/internal/pool"
+	"github.com/adgundersen/crimata-infra/internal/profile"
+	"github.com/adgundersen/crimata-infra/internal/remediation"
+	"github.com/adgundersen/crimata-infra/internal/snapshot"
+	"github.com/adgundersen/crimata-infra/internal/sshca"
+	"github.com/adgundersen/crimata-infra/internal/upgrade"
 	"github.com/go-chi/chi/v5"
 )
 
+type Config struct {
+	// GracePeriod is how long a cancelled instance stays suspended, and can
+	// be reactivated, before it is deprovisioned. Zero deletes immediately.
+	GracePeriod time.Duration
+
+	// DefaultPlan is used when a create request names neither a plan nor a
+	// Stripe price.
+	DefaultPlan string
+
+	// CertRenewBefore is how long before expiry certificates are renewed.
+	CertRenewBefore time.Duration
+
+	// Profile names the provisioning profile new instances pick a version of.
+	Profile string
+
+	// HealthFailureThreshold is how many consecutive failed probes mark an
+	// instance degraded; HealthRetention how long check results are kept.
+	HealthFailureThreshold int
+	HealthRetention        time.Duration
+
+	// AutoRemediate lets the health prober run remediation actions on
+	// degraded instances.
+	AutoRemediate bool
+
+	// ExecFreeForm allows POST /instances/{slug}/exec to run arbitrary
+	// scripts, not just the canned commands.
+	ExecFreeForm bool
+
+	// DiskAlertPercent is the root volume usage at which customers are
+	// warned; zero disables alerts. MetricsRetention is how long samples
+	// are kept.
+	DiskAlertPercent float64
+	MetricsRetention time.Duration
+
+	// SnapshotEvery is how often each hub's root volume is snapshotted;
+	// SnapshotRetention how long snapshots are kept. SnapshotKeep is how
+	// many of each hub's newest snapshots survive retention regardless.
+	SnapshotEvery     time.Duration
+	SnapshotRetention time.Duration
+	SnapshotKeep      int
+}
+
+// metricStore is the part of metrics.Store the handler uses, so the
+// collector can be tested without a database.
+type metricStore interface {
+	Record(samples []metrics.Sample) error
+	LastCollected(instanceID int64) (time.Time, error)
+	Latest(instanceID int64) ([]metrics.Sample, error)
+	Since(instanceID int64, metric string, since time.Time) ([]metrics.Sample, error)
+	Prune(before time.Time) error
+	Raise(a *metrics.Alert) (bool, error)
+	Resolve(instanceID int64, metric string) (bool, error)
+	OpenAlerts(instanceID int64) ([]*metrics.Alert, error)
+}
+
+// notifier sends customer emails; notify.Client in production.
+type notifier interface {
+	SendWelcome(ctx context.Context, email, slug, password string) error
+	SendDataExport(ctx context.Context, email, downloadURL string, expiry time.Duration) error
+	SendDeletionReminder(ctx context.Context, email, slug string, deleteAt time.Time) error
+	SendDiskAlert(ctx context.Context, email, slug string, percent float64) error
+}
+
 type Handler struct {
-	store   *instance.Store
-	compute *compute.Client
-	dns     *dns.Client
-	notify  *notify.Client
-	export  *export.Client
+	cfg          Config
+	store        *instance.Store
+	exports      *export.Store
+	plans        *plan.Store
+	certs        *cert.Store
+	domains      *domain.Store
+	images       *image.Store
+	pool         *pool.Store
+	profiles     *profile.Store
+	upgrades     *upgrade.Store
+	health       *health.Store
+	prober       *health.Prober
+	remediations *remediation.Store
+	audit        *audit.Store
+	metrics      metricStore
+	snapshots    *snapshot.Store
+	migrations   *migration.Store
+	metricSource metrics.Source
+	ca           *sshca.CA // nil if no CA key is configured
+	compute      *compute.Client
+	dns          *dns.Client
+	notify       notifier
+	export       *export.Client
+	acme         *cert.Client
 }
 
 func NewHandler(
+	cfg Config,
 	store *instance.Store,
+	exports *export.Store,
+	plans *plan.Store,
+	certs *cert.Store,
+	domains *domain.Store,
+	images *image.Store,
+	pool *pool.Store,
+	profiles *profile.Store,
+	upgrades *upgrade.Store,
+	health *health.Store,
+	prober *health.Prober,
+	remediations *remediation.Store,
+	audit *audit.Store,
+	metricStore *metrics.Store,
+	snapshots *snapshot.Store,
+	migrations *migration.Store,
+	metricSource metrics.Source,
+	ca *sshca.CA,
 	compute *compute.Client,
 	dns *dns.Client,
 	notify *notify.Client,
 	export *export.Client,
+	acme *cert.Client,
 ) *Handler {
-	return &Handler{store: store, compute: compute, dns: dns, notify: notify, export: export}
+	return &Handler{
+		cfg: cfg, store: store, exports: exports, plans: plans, certs: certs, domains: domains, images: images, pool: pool, profiles: profiles, upgrades: upgrades, health: health, prober: prober,
+		remediations: remediations, audit: audit,
+		metrics: metricStore, snapshots: snapshots, migrations: migrations, metricSource: metricSource, ca: ca, compute: compute, dns: dns, notify: notify, export: export, acme: acme,
+	}
 }
 
 func (h *Handler) Routes() http.Handler {
@@ -44,6 +164,40 @@ func (h *Handler) Routes() http.Handler {
 	r.Post("/instances", h.createInstance)
 	r.Get("/instances/{slug}", h.getInstance)
 	r.Delete("/instances/{slug}", h.deleteInstance)
+	r.Post("/instances/{slug}/suspend", h.suspendInstance)
+	r.Post("/instances/{slug}/resume", h.resumeInstance)
+	r.Post("/instances/{slug}/reactivate", h.reactivateInstance)
+	r.Post("/instances/{slug}/resize", h.resizeInstance)
+	r.Get("/instances/{slug}/volume", h.getVolume)
+	r.Post("/instances/{slug}/volume/expand", h.expandVolume)
+	r.Get("/instances/{slug}/snapshots", h.listSnapshots)
+	r.Post("/instances/{slug}/snapshots", h.createSnapshot)
+	r.Post("/instances/{slug}/snapshots/{id}/restore", h.restoreSnapshot)
+	r.Get("/instances/{slug}/migrations", h.listMigrations)
+	r.Post("/instances/{slug}/migrate", h.migrateInstance)
+	r.Get("/instances/{slug}/certificate", h.getCertificate)
+	r.Get("/instances/{slug}/health", h.getHealth)
+	r.Get("/instances/{slug}/metrics", h.getMetrics)
+	r.Get("/instances/{slug}/actions", h.listActions)
+	r.Post("/instances/{slug}/actions/{action}", h.runAction)
+	r.Post("/instances/{slug}/exec", h.execInstance)
+	r.Get("/instances/{slug}/audit", h.listAudit)
+	r.Post("/instances/{slug}/ssh-cert", h.issueSSHCert)
+	r.Post("/instances/{slug}/ssh-key/rotate", h.rotateSSHKey)
+	r.Post("/instances/{slug}/domains", h.addDomain)
+	r.Get("/instances/{slug}/domains", h.listDomains)
+	r.Delete("/instances/{slug}/domains/{domain}", h.removeDomain)
+	r.Get("/plans", h.listPlans)
+	r.Put("/plans/{name}", h.putPlan)
+	r.Get("/profiles", h.listProfiles)
+	r.Get("/profiles/{name}", h.listProfiles)
+	r.Post("/profiles/{name}", h.createProfile)
+	r.Put("/profiles/{name}/versions/{version}/rollout", h.setProfileRollout)
+	r.Post("/upgrades", h.createUpgrade)
+	r.Get("/upgrades", h.listUpgrades)
+	r.Get("/upgrades/{id}", h.getUpgrade)
+	r.Get("/instances/{slug}/exports", h.listExports)
+	r.Post("/instances/{slug}/exports/{id}/link", h.exportLink)
 	return r
 }
 
@@ -51,6 +205,8 @@ type createRequest struct {
 	StripeCustomerID     string `json:"stripe_customer_id"`
 	StripeSubscriptionID string `json:"stripe_subscription_id"`
 	Email                string `json:"email"`
+	Plan                 string `json:"plan"`            // plan name; takes precedence over the price
+	StripePriceID        string `json:"stripe_price_id"` // mapped to a plan
 }
 
 func (h *Handler) createInstance(w http.ResponseWriter, r *http.Request) {
@@ -67,13 +223,26 @@ func (h *Handler) createInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	p, err := h.resolvePlan(req)
+	if err != nil {
+		http.Error(w, "failed to look up plan", http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		http.Error(w, "unknown plan", http.StatusBadRequest)
+		return
+	}
+
 	slug       := uniqueSlug(h.store, req.Email)
 	password   := randomHex(12)
 	dbPassword := randomHex(24)
 
 	inst := &instance.Instance{
 		StripeCustomerID: req.StripeCustomerID,
+		Email:            req.Email,
 		Slug:             slug,
+		Plan:             p.Name,
+		Region:           h.compute.Region(),
 		Status:           instance.StatusProvisioning,
 	}
 	if err := h.store.Create(inst); err != nil {
@@ -81,7 +250,7 @@ func (h *Handler) createInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go h.provision(context.Background(), inst, req.Email, password, dbPassword)
+	go h.provision(context.Background(), inst, p, req.Email, password, dbPassword)
 
 	jsonResponse(w, inst, http.StatusAccepted)
 }
@@ -93,6 +262,7 @@ func (h *Handler) getInstance(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
+	inst.Region = h.computeFor(inst).Region()
 	jsonResponse(w, inst, http.StatusOK)
 }
 
@@ -104,15 +274,32 @@ func (h *Handler) deleteInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !deletable(inst.Status) {
+		http.Error(w, fmt.Sprintf("cannot cancel instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+	if h.cfg.GracePeriod > 0 {
+		h.scheduleDeletion(w, inst)
+		return
+	}
+
 	go h.deprovision(context.Background(), inst)
 	w.WriteHeader(http.StatusAccepted)
 }
 
 // ── Provisioning ──────────────────────────────────────────────────────────────
 
-func (h *Handler) provision(ctx context.Context, inst *instance.Instance, email, password, dbPassword string) {
-	// 1. Launch EC2
-	ec2, err := h.compute.Launch(ctx, inst.Slug)
+func (h *Handler) provision(ctx context.Context, inst *instance.Instance, p *plan.Plan, email, password, dbPassword string) {
+	// 0. Pick the provisioning profile version before anything is launched
+	prof, err := h.profiles.Pick(h.cfg.Profile, inst.Slug)
+	if err != nil || prof == nil {
+		fmt.Printf("provision: no %s profile version for %s: %v\n", h.cfg.Profile, inst.Slug, err)
+		h.store.UpdateStatus(inst.ID, instance.StatusFailed)
+		return
+	}
+
+	// 1. Claim a warm instance, or launch from the latest golden image
+	ec2, baked, err := h.acquireInstance(ctx, inst.Slug, p)
 	if err != nil {
 		fmt.Printf("provision: launch failed for %s: %v\n", inst.Slug, err)
 		h.store.UpdateStatus(inst.ID, instance.StatusFailed)
@@ -121,26 +308,66 @@ func (h *Handler) provision(ctx context.Context, inst *instance.Instance, email,
 	h.store.UpdateEC2(inst.ID, ec2.InstanceID, ec2.PublicIP)
 	h.store.UpdateSSHKey(inst.ID, ec2.SSHPrivateKey)
 
-	// 2. Wait for instance to be ready
-	if err := h.compute.WaitUntilReady(ctx, ec2.InstanceID, ec2.PublicIP); err != nil {
+	// 1b. Pin a stable address so stop/start doesn't break DNS
+	if h.compute.ElasticIPEnabled() {
+		allocationID, ip, err := h.compute.AssignElasticIP(ctx, inst.Slug, ec2.InstanceID)
+		if err != nil {
+			fmt.Printf("provision: elastic ip failed for %s: %v\n", inst.Slug, err)
+			h.store.UpdateStatus(inst.ID, instance.StatusFailed)
+			return
+		}
+		ec2.PublicIP, ec2.EIPAllocationID = ip, allocationID
+		h.store.UpdateElasticIP(inst.ID, allocationID, ip)
+	}
+
+	// 2. Wait for instance to be ready and pick up its real addresses
+	ready, err := h.compute.WaitUntilReady(ctx, ec2.InstanceID)
+	if err != nil {
 		fmt.Printf("provision: wait failed for %s: %v\n", inst.Slug, err)
-		h.store.UpdateStatus(inst.ID, instance.StatusFailed)
+		h.failProvision(ctx, inst, ec2.EIPAllocationID)
 		return
 	}
-
-	// 3. Run provisioning script via SSH
-	if err := h.compute.Provision(ctx, ec2.PublicIP, ec2.SSHPrivateKey, inst.Slug, password, dbPassword); err != nil {
+	ec2.PublicIP, ec2.PrivateIP, ec2.IPv6 = ready.PublicIP, ready.PrivateIP, ready.IPv6
+	h.store.UpdateAddresses(inst.ID, ec2.PublicIP, ec2.PrivateIP, ec2.IPv6)
+
+	// 3. Run the provisioning profile's script via SSH
+	if err := h.compute.Provision(ctx, ec2.Host(), ec2.SSHPrivateKey, compute.ProvisionSpec{
+		Script: prof.Script,
+		Params: prof.Params,
+		Args:   []string{inst.Slug, password, dbPassword},
+		Baked:  baked,
+	}); err != nil {
 		fmt.Printf("provision: script failed for %s: %v\n", inst.Slug, err)
-		h.store.UpdateStatus(inst.ID, instance.StatusFailed)
+		h.failProvision(ctx, inst, ec2.EIPAllocationID)
 		return
 	}
+	inst.Profile, inst.ProfileVersion = prof.Name, prof.Version
+	h.store.UpdateProfile(inst.ID, prof.Name, prof.Version)
+
+	// 3b. Scope operator certificates to this slug; warm instances were
+	// launched under a placeholder one
+	inst.EC2InstanceID, inst.EC2PublicIP, inst.EC2IPv6, inst.SSHPrivateKey = ec2.InstanceID, ec2.PublicIP, ec2.IPv6, ec2.SSHPrivateKey
+	if h.ca != nil {
+		if err := h.trustCA(ctx, inst); err != nil {
+			fmt.Printf("provision: ssh ca failed for %s: %v\n", inst.Slug, err)
+		}
+	}
 
-	// 4. Create Route53 record
-	if err := h.dns.CreateRecord(ctx, inst.Slug, ec2.PublicIP); err != nil {
+	// 4. Issue TLS certificate (DNS-01, so it doesn't wait on the A record)
+	if err := h.issueCertificate(ctx, inst); err != nil {
+		fmt.Printf("provision: certificate failed for %s: %v\n", inst.Slug, err)
+	}
+
+	// 5. Create Route53 record and wait until it resolves
+	if err := retry(ctx, 3, 30*time.Second, func() error {
+		return h.dns.CreateRecord(ctx, inst.Slug, ec2.PublicIP, ec2.IPv6)
+	}); err != nil {
 		fmt.Printf("provision: dns failed for %s: %v\n", inst.Slug, err)
+		h.failProvision(ctx, inst, ec2.EIPAllocationID)
+		return
 	}
 
-	// 5. Send welcome email
+	// 6. Send welcome email
 	if err := h.notify.SendWelcome(ctx, email, inst.Slug, password); err != nil {
 		fmt.Printf("provision: email failed for %s: %v\n", inst.Slug, err)
 	}
@@ -149,27 +376,52 @@ func (h *Handler) provision(ctx context.Context, inst *instance.Instance, email,
 	fmt.Printf("provision: %s is live at %s.crimata.com\n", email, inst.Slug)
 }
 
+// failProvision marks an instance whose provisioning failed, releasing its
+// Elastic IP, if it got one, rather than holding it until deletion.
+func (h *Handler) failProvision(ctx context.Context, inst *instance.Instance, allocationID string) {
+	h.store.UpdateStatus(inst.ID, instance.StatusFailed)
+	if allocationID == "" {
+		return
+	}
+	if err := h.computeFor(inst).ReleaseElasticIP(ctx, allocationID); err != nil {
+		fmt.Printf("provision: elastic ip release failed for %s: %v\n", inst.Slug, err)
+		return
+	}
+	h.store.UpdateElasticIP(inst.ID, "", "")
+}
+
 func (h *Handler) deprovision(ctx context.Context, inst *instance.Instance) {
 	h.store.UpdateStatus(inst.ID, instance.StatusCancelled)
 
+	// 0. Suspended instances are stopped; the export needs them running
+	if inst.Status == instance.StatusSuspended || inst.Status == instance.StatusPendingDeletion {
+		if _, err := h.computeFor(inst).Start(ctx, inst.EC2InstanceID); err != nil {
+			fmt.Printf("deprovision: start failed for %s: %v\n", inst.Slug, err)
+		}
+	}
+
 	// 1. Export data and email download link
-	downloadURL, err := h.export.Export(ctx, inst.EC2InstanceID, inst.Slug)
-	if err != nil {
+	if err := h.exportAndNotify(ctx, inst); err != nil {
 		fmt.Printf("deprovision: export failed for %s: %v\n", inst.Slug, err)
-	} else {
-		h.notify.SendDataExport(ctx, inst.StripeCustomerID, downloadURL)
 	}
 
 	// 2. Terminate EC2
-	if err := h.compute.Terminate(ctx, inst.EC2InstanceID); err != nil {
+	if err := h.computeFor(inst).Terminate(ctx, inst.EC2InstanceID); err != nil {
 		fmt.Printf("deprovision: terminate failed for %s: %v\n", inst.Slug, err)
 	}
 
 	// 3. Remove Route53 record
-	if err := h.dns.DeleteRecord(ctx, inst.Slug, inst.EC2PublicIP); err != nil {
+	if err := h.dns.DeleteRecord(ctx, inst.Slug); err != nil {
 		fmt.Printf("deprovision: dns delete failed for %s: %v\n", inst.Slug, err)
 	}
 
+	// 4. Release Elastic IP
+	if inst.EIPAllocationID != "" {
+		if err := h.computeFor(inst).ReleaseElasticIP(ctx, inst.EIPAllocationID); err != nil {
+			fmt.Printf("deprovision: elastic ip release failed for %s: %v\n", inst.Slug, err)
+		}
+	}
+
 	fmt.Printf("deprovision: %s cleaned up\n", inst.Slug)
 }
 
@@ -193,6 +445,26 @@ func uniqueSlug(store *instance.Store, email string) string {
 	}
 }
 
+// retry calls fn up to attempts times, waiting delay between failures, and
+// returns the last error.
+func retry(ctx context.Context, attempts int, delay time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i == attempts-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
+
 func randomHex(n int) string {
 	b := make([]byte, n)
 	rand.Read(b)
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/cert"
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) getCertificate(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	rec, err := h.certs.GetByInstance(inst.ID)
+	if err != nil || rec == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, rec, http.StatusOK)
+}
+
+// issueCertificate obtains a certificate for the instance's hostname and
+// active custom domains, installs it into nginx and records its expiry.
+func (h *Handler) issueCertificate(ctx context.Context, inst *instance.Instance) error {
+	domains, err := h.activeDomains(inst)
+	if err != nil {
+		return err
+	}
+	return h.issueCertificateFor(ctx, inst, append([]string{h.dns.Hostname(inst.Slug)}, domains...))
+}
+
+func (h *Handler) issueCertificateFor(ctx context.Context, inst *instance.Instance, domains []string) error {
+	t := target(inst)
+	hostname := h.dns.Hostname(inst.Slug)
+	dnsSolver := cert.DNSSolver{DNS: h.dns}
+	httpSolver := cert.HTTPSolver{
+		Publish: func(ctx context.Context, path, content string) error {
+			return h.compute.PublishChallenge(ctx, t, path, content)
+		},
+		Unpublish: func(ctx context.Context, path string) error {
+			return h.compute.RemoveChallenge(ctx, t, path)
+		},
+	}
+
+	// Our own hostname validates through Route53; customer domains over HTTP
+	c, err := h.acme.Obtain(ctx, domains, func(domain string) cert.Solver {
+		if domain == hostname {
+			return dnsSolver
+		}
+		return httpSolver
+	})
+	if err != nil {
+		return err
+	}
+	if err := h.compute.InstallCertificate(ctx, t, c.CertPEM, c.KeyPEM); err != nil {
+		return err
+	}
+	return h.certs.Save(inst.ID, c)
+}
+
+// RunCertRenewal issues certificates for active instances that have none
+// and renews those close to expiry, checking every interval until ctx is
+// done.
+func (h *Handler) RunCertRenewal(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.renewCertificates(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) renewCertificates(ctx context.Context) {
+	ids, err := h.certs.ListDue(time.Now().Add(h.cfg.CertRenewBefore))
+	if err != nil {
+		fmt.Printf("certs: list due: %v\n", err)
+		return
+	}
+
+	for _, id := range ids {
+		inst, err := h.store.Get(id)
+		if err != nil || inst == nil {
+			continue
+		}
+		if err := h.issueCertificate(ctx, inst); err != nil {
+			fmt.Printf("certs: renewal failed for %s: %v\n", inst.Slug, err)
+			continue
+		}
+		fmt.Printf("certs: renewed %s\n", inst.Slug)
+	}
+}
+
+// target addresses an instance for remote commands.
+func target(inst *instance.Instance) compute.Target {
+	host := inst.EC2PublicIP
+	if host == "" {
+		host = inst.EC2IPv6
+	}
+	return compute.Target{
+		InstanceID:    inst.EC2InstanceID,
+		Region:        inst.Region,
+		Host:          host,
+		SSHPrivateKey: inst.SSHPrivateKey,
+	}
+}
+
+// computeFor returns the compute client for the region inst runs in.
+func (h *Handler) computeFor(inst *instance.Instance) *compute.Client {
+	return h.compute.In(inst.Region)
+}
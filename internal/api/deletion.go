@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+// reminderDays are the T-minus points at which a customer is reminded of an
+// upcoming deletion, largest first.
+var reminderDays = []int{7, 1}
+
+type deletionResponse struct {
+	Slug                string    `json:"slug"`
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
+
+// deletable reports whether an instance can be cancelled in its current
+// status. Provisioning, resizes, restores, migrations and the like keep
+// launching and re-pointing hosts in the background, so they must finish
+// first.
+func deletable(status instance.Status) bool {
+	switch status {
+	case instance.StatusActive, instance.StatusDegraded, instance.StatusSuspended, instance.StatusPendingDeletion,
+		instance.StatusFailed:
+		return true
+	}
+	return false
+}
+
+// scheduleDeletion suspends a cancelled instance and schedules its
+// deprovisioning once the grace period has passed.
+func (h *Handler) scheduleDeletion(w http.ResponseWriter, inst *instance.Instance) {
+	if inst.Status == instance.StatusFailed {
+		// Nothing worth keeping — provisioning never finished
+		go h.deprovision(context.Background(), inst)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if inst.DeletionScheduledAt != nil {
+		jsonResponse(w, deletionResponse{inst.Slug, *inst.DeletionScheduledAt}, http.StatusAccepted)
+		return
+	}
+
+	at := time.Now().Add(h.cfg.GracePeriod)
+	if err := h.store.ScheduleDeletion(inst.ID, at); err != nil {
+		http.Error(w, "failed to schedule deletion", http.StatusInternalServerError)
+		return
+	}
+
+	if ok, _ := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive, instance.StatusDegraded}, instance.StatusSuspending); ok {
+		go func() {
+			if err := h.suspend(context.Background(), inst, instance.StatusPendingDeletion); err != nil {
+				fmt.Printf("cancel: suspend failed for %s: %v\n", inst.Slug, err)
+			}
+		}()
+	} else {
+		h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusSuspended}, instance.StatusPendingDeletion)
+	}
+
+	fmt.Printf("cancel: %s scheduled for deletion at %s\n", inst.Slug, at.Format(time.RFC3339))
+	jsonResponse(w, deletionResponse{inst.Slug, at}, http.StatusAccepted)
+}
+
+// reactivateInstance cancels a scheduled deletion and resumes the instance.
+func (h *Handler) reactivateInstance(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusPendingDeletion}, instance.StatusResuming)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot reactivate instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+	if err := h.store.CancelDeletion(inst.ID); err != nil {
+		h.store.UpdateStatus(inst.ID, instance.StatusPendingDeletion)
+		http.Error(w, "failed to cancel deletion", http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		if err := h.resume(context.Background(), inst); err != nil {
+			fmt.Printf("reactivate: %s: %v\n", inst.Slug, err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RunDeletionScheduler sends deletion reminders and deprovisions instances
+// whose grace period has run out, checking every interval until ctx is done.
+func (h *Handler) RunDeletionScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.processDeletions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) processDeletions(ctx context.Context) {
+	insts, err := h.store.ListPendingDeletion()
+	if err != nil {
+		fmt.Printf("scheduler: list pending deletions: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, inst := range insts {
+		at := *inst.DeletionScheduledAt
+
+		if !now.Before(at) {
+			ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusPendingDeletion}, instance.StatusCancelled)
+			if err != nil || !ok {
+				continue
+			}
+			h.deprovision(ctx, inst)
+			continue
+		}
+
+		// Send the nearest reminder not yet sent, skipping any we were late for
+		due := 0
+		for _, days := range reminderDays {
+			if now.After(at.Add(-time.Duration(days)*24*time.Hour)) &&
+				(inst.DeletionReminderDays == 0 || days < inst.DeletionReminderDays) {
+				due = days
+			}
+		}
+		if due == 0 {
+			continue
+		}
+		if err := h.notify.SendDeletionReminder(ctx, inst.Email, inst.Slug, at); err != nil {
+			fmt.Printf("scheduler: reminder failed for %s: %v\n", inst.Slug, err)
+			continue
+		}
+		h.store.UpdateDeletionReminder(inst.ID, due)
+	}
+}
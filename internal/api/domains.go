@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/domain"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+// domainVerifyTimeout is how long a customer has to publish the TXT record.
+const domainVerifyTimeout = 7 * 24 * time.Hour
+
+// Failed activations back off from domainRetryMin, doubling up to
+// domainRetryMax, to stay clear of Let's Encrypt's failed validation limits.
+const (
+	domainRetryMin = 10 * time.Minute
+	domainRetryMax = 24 * time.Hour
+)
+
+var hostnameRe = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+type domainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// domainResponse tells the customer which records to publish.
+type domainResponse struct {
+	*domain.Domain
+	Verification dnsInstruction `json:"verification"`
+	Target       dnsInstruction `json:"target"`
+}
+
+type dnsInstruction struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// normalizeDomain lowercases a hostname and drops any trailing root dot.
+func normalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+func (h *Handler) domainResponse(inst *instance.Instance, d *domain.Domain) domainResponse {
+	return domainResponse{
+		Domain:       d,
+		Verification: dnsInstruction{Type: "TXT", Name: d.VerificationName(), Value: d.VerificationToken},
+		Target:       dnsInstruction{Type: "CNAME", Name: d.Name, Value: h.dns.Hostname(inst.Slug)},
+	}
+}
+
+func (h *Handler) addDomain(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var req domainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	name := normalizeDomain(req.Domain)
+	if !hostnameRe.MatchString(name) || strings.HasSuffix(name, "."+h.dns.BaseDomain()) {
+		http.Error(w, "invalid domain", http.StatusBadRequest)
+		return
+	}
+
+	existing, err := h.domains.GetByName(name)
+	if err != nil {
+		http.Error(w, "failed to look up domain", http.StatusInternalServerError)
+		return
+	}
+	if existing != nil {
+		if existing.InstanceID != inst.ID {
+			http.Error(w, "domain already in use", http.StatusConflict)
+			return
+		}
+		jsonResponse(w, h.domainResponse(inst, existing), http.StatusOK)
+		return
+	}
+
+	d := &domain.Domain{
+		InstanceID:        inst.ID,
+		Name:              name,
+		Status:            domain.StatusPending,
+		VerificationToken: "crimata-verify=" + randomHex(16),
+	}
+	if err := h.domains.Create(d); err != nil {
+		http.Error(w, "failed to create domain", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, h.domainResponse(inst, d), http.StatusCreated)
+}
+
+func (h *Handler) listDomains(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	domains, err := h.domains.ListByInstance(inst.ID)
+	if err != nil {
+		http.Error(w, "failed to list domains", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]domainResponse, len(domains))
+	for i, d := range domains {
+		resp[i] = h.domainResponse(inst, d)
+	}
+	jsonResponse(w, resp, http.StatusOK)
+}
+
+func (h *Handler) removeDomain(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	d, err := h.domains.GetByName(normalizeDomain(chi.URLParam(r, "domain")))
+	if err != nil || d == nil || d.InstanceID != inst.ID {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err := h.domains.Delete(d.ID); err != nil {
+		http.Error(w, "failed to remove domain", http.StatusInternalServerError)
+		return
+	}
+
+	// Stop serving it and drop it from the certificate
+	if d.Status == domain.StatusActive {
+		go func() {
+			ctx := context.Background()
+			if err := h.applyDomains(ctx, inst, nil); err != nil {
+				fmt.Printf("domains: removing %s from %s failed: %v\n", d.Name, inst.Slug, err)
+			}
+		}()
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// activeDomains lists the custom domains an instance currently serves.
+func (h *Handler) activeDomains(inst *instance.Instance) ([]string, error) {
+	domains, err := h.domains.ListByInstance(inst.ID)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, d := range domains {
+		if d.Status == domain.StatusActive {
+			names = append(names, d.Name)
+		}
+	}
+	return names, nil
+}
+
+// applyDomains reconfigures nginx and reissues the certificate for the
+// instance's active domains plus any extra being activated.
+func (h *Handler) applyDomains(ctx context.Context, inst *instance.Instance, extra []string) error {
+	names, err := h.activeDomains(inst)
+	if err != nil {
+		return err
+	}
+	names = append(names, extra...)
+
+	if err := h.issueCertificateFor(ctx, inst, append([]string{h.dns.Hostname(inst.Slug)}, names...)); err != nil {
+		return err
+	}
+	return h.compute.SetServerNames(ctx, target(inst), names)
+}
+
+// RunDomainVerifier checks pending domains for their TXT record and
+// activates verified ones, every interval until ctx is done.
+func (h *Handler) RunDomainVerifier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		h.verifyDomains(ctx)
+		h.activateDomains(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) verifyDomains(ctx context.Context) {
+	pending, err := h.domains.ListByStatus(domain.StatusPending)
+	if err != nil {
+		fmt.Printf("domains: list pending: %v\n", err)
+		return
+	}
+
+	for _, d := range pending {
+		records, _ := net.DefaultResolver.LookupTXT(ctx, d.VerificationName())
+		verified := false
+		for _, txt := range records {
+			if strings.TrimSpace(txt) == d.VerificationToken {
+				verified = true
+				break
+			}
+		}
+
+		switch {
+		case verified:
+			h.domains.MarkVerified(d.ID)
+			fmt.Printf("domains: %s verified\n", d.Name)
+		case time.Since(d.CreatedAt) > domainVerifyTimeout:
+			h.domains.UpdateStatus(d.ID, domain.StatusFailed)
+			fmt.Printf("domains: %s was never verified\n", d.Name)
+		}
+	}
+}
+
+// activateDomains serves verified domains once the certificate can be
+// issued, which requires the customer's CNAME to already point at us. Each
+// domain backs off on failure rather than retrying every interval.
+func (h *Handler) activateDomains(ctx context.Context) {
+	verified, err := h.domains.ListByStatus(domain.StatusVerified)
+	if err != nil {
+		fmt.Printf("domains: list verified: %v\n", err)
+		return
+	}
+
+	for _, d := range verified {
+		inst, err := h.store.Get(d.InstanceID)
+		if err != nil || inst == nil || (inst.Status != instance.StatusActive && inst.Status != instance.StatusDegraded) {
+			continue
+		}
+		if d.NextAttemptAt != nil && time.Now().Before(*d.NextAttemptAt) {
+			continue
+		}
+		if err := h.applyDomains(ctx, inst, []string{d.Name}); err != nil {
+			retry := min(domainRetryMin<<min(d.ActivationAttempts, 16), domainRetryMax)
+			h.domains.DeferActivation(d.ID, time.Now().Add(retry))
+			fmt.Printf("domains: activating %s failed, retrying in %s: %v\n", d.Name, retry, err)
+			continue
+		}
+		h.domains.UpdateStatus(d.ID, domain.StatusActive)
+		fmt.Printf("domains: %s now serves %s\n", d.Name, inst.Slug)
+	}
+}
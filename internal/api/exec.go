@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/adgundersen/crimata-infra/internal/audit"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+// execCommands are the canned diagnostics any operator may run.
+var execCommands = map[string]string{
+	"status":     "systemctl --no-pager status 'crimata-*' nginx postgresql",
+	"logs":       "journalctl --no-pager -n 200 -u 'crimata-*'",
+	"nginx-log":  "tail -n 200 /var/log/nginx/error.log",
+	"nginx-test": "nginx -t",
+	"disk":       "df -h",
+	"memory":     "free -m",
+	"processes":  "ps aux --sort=-%cpu | head -n 25",
+}
+
+type execRequest struct {
+	Command string `json:"command"` // a name from execCommands
+	Script  string `json:"script"`  // free-form, if enabled
+}
+
+// operatorHeader names the operator behind a request, for the audit log.
+// It is self-asserted: the API has no authentication of its own, so the
+// name is only as trustworthy as whatever fronts the API and sets it.
+const operatorHeader = "X-Operator"
+
+// execOutputLimit caps the output kept in the audit log; the response
+// streams all of it.
+const execOutputLimit = 64 << 10
+
+// execInstance runs a command on the instance and streams its output as
+// plain text, ending with a status line. Every run is recorded in the audit
+// log with the operator's name. Output streams as it is produced over SSH;
+// with EXECUTOR=ssm it arrives all at once when the command finishes.
+func (h *Handler) execInstance(w http.ResponseWriter, r *http.Request) {
+	operator := strings.TrimSpace(r.Header.Get(operatorHeader))
+	if operator == "" {
+		http.Error(w, operatorHeader+" header is required", http.StatusBadRequest)
+		return
+	}
+
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var req execRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	var script, detail string
+	switch {
+	case req.Command != "" && req.Script != "":
+		http.Error(w, "give either command or script, not both", http.StatusBadRequest)
+		return
+	case req.Command != "":
+		var ok bool
+		if script, ok = execCommands[req.Command]; !ok {
+			http.Error(w, "unknown command", http.StatusBadRequest)
+			return
+		}
+		detail = req.Command
+	case req.Script != "":
+		if !h.cfg.ExecFreeForm {
+			http.Error(w, "free-form scripts are disabled", http.StatusForbidden)
+			return
+		}
+		script, detail = req.Script, req.Script
+	default:
+		http.Error(w, "command or script is required", http.StatusBadRequest)
+		return
+	}
+
+	if inst.Status != instance.StatusActive && inst.Status != instance.StatusDegraded {
+		http.Error(w, fmt.Sprintf("cannot exec on instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	entry := &audit.Entry{InstanceID: inst.ID, Operator: operator, Action: "exec", Detail: detail}
+	if err := h.audit.Start(entry); err != nil {
+		http.Error(w, "failed to write audit log", http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("exec: %s on %s: %s\n", operator, inst.Slug, detail)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	out := &tailBuffer{limit: execOutputLimit}
+	runErr := h.compute.Stream(r.Context(), target(inst), script, io.MultiWriter(flushWriter{w}, out))
+
+	status := "ok"
+	if runErr != nil {
+		status = runErr.Error()
+	}
+	fmt.Fprintf(w, "\n[crimata] exit: %s\n", status)
+
+	if err := h.audit.Finish(entry, out.String(), runErr); err != nil {
+		fmt.Printf("exec: failed to record audit entry %d: %v\n", entry.ID, err)
+	}
+}
+
+func (h *Handler) listAudit(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	entries, err := h.audit.ListByInstance(inst.ID, 100)
+	if err != nil {
+		http.Error(w, "failed to list audit log", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*audit.Entry{}
+	}
+	jsonResponse(w, entries, http.StatusOK)
+}
+
+// flushWriter pushes each write to the client straight away.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
+
+// tailBuffer keeps only the last limit bytes written to it, so a script that
+// prints without end can't exhaust memory.
+type tailBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	// Trim once the buffer doubles, keeping writes amortised O(1)
+	if len(t.buf) > 2*t.limit {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return tail(string(t.buf), t.limit)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/export"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+type linkRequest struct {
+	ExpiresIn string `json:"expires_in"` // Go duration, e.g. "72h"; empty uses the default
+	Email     bool   `json:"email"`      // also email the link to the customer
+}
+
+type linkResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (h *Handler) listExports(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	recs, err := h.exports.ListByInstance(inst.ID)
+	if err != nil {
+		http.Error(w, "failed to list exports", http.StatusInternalServerError)
+		return
+	}
+	if recs == nil {
+		recs = []*export.Record{}
+	}
+	jsonResponse(w, recs, http.StatusOK)
+}
+
+// exportLink re-presigns a download link for a retained export.
+func (h *Handler) exportLink(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	rec, err := h.exports.Get(id)
+	if err != nil || rec == nil || rec.InstanceID != inst.ID {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var req linkRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	var expiry time.Duration
+	if req.ExpiresIn != "" {
+		expiry, err = time.ParseDuration(req.ExpiresIn)
+		if err != nil || expiry <= 0 {
+			http.Error(w, "invalid expires_in", http.StatusBadRequest)
+			return
+		}
+	}
+	// Instances created before emails were recorded have none to send to
+	if req.Email && inst.Email == "" {
+		http.Error(w, "instance has no email on record", http.StatusConflict)
+		return
+	}
+
+	ok, err := h.export.Exists(r.Context(), rec.S3Key)
+	if err != nil {
+		http.Error(w, "failed to look up export", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "export no longer retained", http.StatusGone)
+		return
+	}
+
+	url, expiry, err := h.export.Presign(r.Context(), rec.S3Key, expiry)
+	if err != nil {
+		http.Error(w, "failed to presign export", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Email {
+		if err := h.notify.SendDataExport(r.Context(), inst.Email, url, expiry); err != nil {
+			http.Error(w, "failed to send email", http.StatusBadGateway)
+			return
+		}
+	}
+
+	jsonResponse(w, linkResponse{URL: url, ExpiresAt: time.Now().Add(expiry)}, http.StatusOK)
+}
+
+// exportAndNotify exports an instance's data, records the archive and emails
+// the customer a download link. Instances with no email on record keep the
+// export, which staff can re-link from the exports endpoint.
+func (h *Handler) exportAndNotify(ctx context.Context, inst *instance.Instance) error {
+	key, err := h.export.Export(ctx, inst.Region, inst.EC2InstanceID, inst.Slug, h.exportDirs(inst))
+	if err != nil {
+		return err
+	}
+
+	rec := &export.Record{InstanceID: inst.ID, Slug: inst.Slug, S3Key: key}
+	if err := h.exports.Create(rec); err != nil {
+		fmt.Printf("export: failed to record %s for %s: %v\n", key, inst.Slug, err)
+	}
+
+	if inst.Email == "" {
+		fmt.Printf("export: %s has no email on record, not sending link to %s\n", inst.Slug, key)
+		return nil
+	}
+	url, expiry, err := h.export.Presign(ctx, key, 0)
+	if err != nil {
+		return err
+	}
+	return h.notify.SendDataExport(ctx, inst.Email, url, expiry)
+}
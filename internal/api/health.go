@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/health"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+// probeConcurrency bounds how many instances are probed at once.
+const probeConcurrency = 20
+
+type healthResponse struct {
+	Slug                string          `json:"slug"`
+	Status              instance.Status `json:"status"`
+	Healthy             bool            `json:"healthy"`
+	ConsecutiveFailures int             `json:"consecutive_failures"`
+	Checks              []health.Check  `json:"checks"`
+	History             []health.Check  `json:"history,omitempty"`
+}
+
+// getHealth returns the latest check of each endpoint, plus the last day of
+// history with ?history=1.
+func (h *Handler) getHealth(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	checks, err := h.health.Latest(inst.ID)
+	if err != nil {
+		http.Error(w, "failed to load health checks", http.StatusInternalServerError)
+		return
+	}
+	resp := healthResponse{
+		Slug:                inst.Slug,
+		Status:              inst.Status,
+		Healthy:             len(checks) > 0 && health.Healthy(checks),
+		ConsecutiveFailures: inst.HealthFailures,
+		Checks:              checks,
+	}
+	if resp.Checks == nil {
+		resp.Checks = []health.Check{}
+	}
+	if r.URL.Query().Get("history") != "" {
+		if resp.History, err = h.health.Since(inst.ID, time.Now().Add(-24*time.Hour)); err != nil {
+			http.Error(w, "failed to load health checks", http.StatusInternalServerError)
+			return
+		}
+	}
+	jsonResponse(w, resp, http.StatusOK)
+}
+
+// ── Prober ────────────────────────────────────────────────────────────────────
+
+// RunHealthProber probes every running hub on each tick until ctx is
+// cancelled.
+func (h *Handler) RunHealthProber(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.probeInstances(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) probeInstances(ctx context.Context) {
+	insts, err := h.store.ListByStatus(instance.StatusActive, instance.StatusDegraded)
+	if err != nil {
+		fmt.Printf("health: list instances failed: %v\n", err)
+		return
+	}
+
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for _, inst := range insts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(inst *instance.Instance) {
+			defer func() { <-sem; wg.Done() }()
+			h.probeInstance(ctx, inst)
+		}(inst)
+	}
+	wg.Wait()
+
+	if err := h.health.Prune(time.Now().Add(-h.cfg.HealthRetention)); err != nil {
+		fmt.Printf("health: prune failed: %v\n", err)
+	}
+}
+
+// probeInstance records one round of checks and moves the instance between
+// active and degraded once failures reach the threshold or it recovers.
+func (h *Handler) probeInstance(ctx context.Context, inst *instance.Instance) {
+	checks := h.prober.Probe(ctx, inst.ID, target(inst).Host, h.dns.Hostname(inst.Slug))
+	if err := h.health.Record(checks); err != nil {
+		fmt.Printf("health: record failed for %s: %v\n", inst.Slug, err)
+	}
+
+	if health.Healthy(checks) {
+		if inst.HealthFailures > 0 {
+			h.store.UpdateHealthFailures(inst.ID, 0)
+		}
+		if ok, _ := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusDegraded}, instance.StatusActive); ok {
+			fmt.Printf("health: %s recovered\n", inst.Slug)
+		}
+		return
+	}
+
+	failures, err := h.store.IncrementHealthFailures(inst.ID)
+	if err != nil {
+		fmt.Printf("health: record failure for %s failed: %v\n", inst.Slug, err)
+		return
+	}
+	threshold := max(h.cfg.HealthFailureThreshold, 1)
+	if failures < threshold {
+		return
+	}
+	if ok, _ := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive}, instance.StatusDegraded); ok {
+		fmt.Printf("health: %s degraded after %d failed probes\n", inst.Slug, failures)
+	}
+	// Remediate on every threshold-th failure so a hub that stays down
+	// doesn't queue an attempt per probe
+	if h.cfg.AutoRemediate && failures%threshold == 0 {
+		go h.autoRemediate(context.Background(), inst, checks)
+	}
+}
+
+// waitHealthy probes an instance every 10 seconds until every endpoint
+// answers or within has passed.
+func (h *Handler) waitHealthy(ctx context.Context, inst *instance.Instance, within time.Duration) error {
+	var checks []health.Check
+	deadline := time.Now().Add(within)
+	for attempt := 0; attempt == 0 || time.Now().Before(deadline); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(10 * time.Second):
+			}
+		}
+		checks = h.prober.Probe(ctx, inst.ID, target(inst).Host, h.dns.Hostname(inst.Slug))
+		if health.Healthy(checks) {
+			return nil
+		}
+	}
+	for _, c := range checks {
+		if !c.OK {
+			return fmt.Errorf("health check %s: %s", c.Path, c.Error)
+		}
+	}
+	return nil
+}
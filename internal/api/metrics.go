@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// diskAlertHysteresis is how far below the threshold disk usage must fall
+// before an alert resolves, so usage hovering at the line doesn't flap.
+const diskAlertHysteresis = 5
+
+type metricsResponse struct {
+	Slug    string           `json:"slug"`
+	Latest  []metrics.Sample `json:"latest"`
+	Samples []metrics.Sample `json:"samples"`
+	Alerts  []*metrics.Alert `json:"alerts"`
+}
+
+// getMetrics returns an instance's latest utilisation, its samples over
+// ?since (a Go duration, default 24h) optionally narrowed to ?metric, and
+// its open alerts.
+func (h *Handler) getMetrics(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	since := 24 * time.Hour
+	if s := r.URL.Query().Get("since"); s != "" {
+		if since, err = time.ParseDuration(s); err != nil || since <= 0 {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+	metric := r.URL.Query().Get("metric")
+	if metric != "" && !validMetric(metric) {
+		http.Error(w, "unknown metric", http.StatusBadRequest)
+		return
+	}
+
+	resp := metricsResponse{Slug: inst.Slug}
+	if resp.Latest, err = h.metrics.Latest(inst.ID); err == nil {
+		if resp.Samples, err = h.metrics.Since(inst.ID, metric, time.Now().Add(-since)); err == nil {
+			resp.Alerts, err = h.metrics.OpenAlerts(inst.ID)
+		}
+	}
+	if err != nil {
+		http.Error(w, "failed to load metrics", http.StatusInternalServerError)
+		return
+	}
+	if resp.Latest == nil {
+		resp.Latest = []metrics.Sample{}
+	}
+	if resp.Samples == nil {
+		resp.Samples = []metrics.Sample{}
+	}
+	if resp.Alerts == nil {
+		resp.Alerts = []*metrics.Alert{}
+	}
+	jsonResponse(w, resp, http.StatusOK)
+}
+
+func validMetric(name string) bool {
+	for _, m := range metrics.Names {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ── Collector ─────────────────────────────────────────────────────────────────
+
+// RunMetricsCollector pulls new datapoints for every running hub on each
+// tick until ctx is cancelled.
+func (h *Handler) RunMetricsCollector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.collectMetrics(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) collectMetrics(ctx context.Context, interval time.Duration) {
+	insts, err := h.store.ListByStatus(instance.StatusActive, instance.StatusDegraded)
+	if err != nil {
+		fmt.Printf("metrics: list instances failed: %v\n", err)
+		return
+	}
+
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for _, inst := range insts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(inst *instance.Instance) {
+			defer func() { <-sem; wg.Done() }()
+			h.collectInstance(ctx, inst, interval)
+		}(inst)
+	}
+	wg.Wait()
+
+	if err := h.metrics.Prune(time.Now().Add(-h.cfg.MetricsRetention)); err != nil {
+		fmt.Printf("metrics: prune failed: %v\n", err)
+	}
+}
+
+// collectInstance fetches datapoints newer than the last stored one, then
+// raises or resolves the instance's disk alert from the newest.
+func (h *Handler) collectInstance(ctx context.Context, inst *instance.Instance, interval time.Duration) {
+	since, err := h.metrics.LastCollected(inst.ID)
+	if err != nil {
+		fmt.Printf("metrics: last sample of %s: %v\n", inst.Slug, err)
+		return
+	}
+	if since.IsZero() {
+		since = time.Now().Add(-interval)
+	}
+
+	samples, err := h.metricSource.Collect(ctx, inst.Region, inst.EC2InstanceID, since)
+	if err != nil {
+		fmt.Printf("metrics: collect failed for %s: %v\n", inst.Slug, err)
+		return
+	}
+	if len(samples) == 0 {
+		return
+	}
+	var disk *metrics.Sample
+	for i := range samples {
+		samples[i].InstanceID = inst.ID
+		if samples[i].Metric == metrics.Disk && (disk == nil || samples[i].CollectedAt.After(disk.CollectedAt)) {
+			disk = &samples[i]
+		}
+	}
+	if err := h.metrics.Record(samples); err != nil {
+		fmt.Printf("metrics: record failed for %s: %v\n", inst.Slug, err)
+	}
+	if disk != nil {
+		h.checkDisk(ctx, inst, disk.Value)
+	}
+}
+
+func (h *Handler) checkDisk(ctx context.Context, inst *instance.Instance, used float64) {
+	threshold := h.cfg.DiskAlertPercent
+	if threshold <= 0 {
+		return
+	}
+
+	if used < threshold {
+		if used < threshold-diskAlertHysteresis {
+			if ok, _ := h.metrics.Resolve(inst.ID, metrics.Disk); ok {
+				fmt.Printf("metrics: %s disk back to %.0f%%\n", inst.Slug, used)
+			}
+		}
+		return
+	}
+
+	raised, err := h.metrics.Raise(&metrics.Alert{InstanceID: inst.ID, Metric: metrics.Disk, Threshold: threshold, Value: used})
+	if err != nil || !raised {
+		return
+	}
+	fmt.Printf("metrics: %s disk at %.0f%%\n", inst.Slug, used)
+	if err := h.notify.SendDiskAlert(ctx, inst.Email, inst.Slug, used); err != nil {
+		fmt.Printf("metrics: disk alert email failed for %s: %v\n", inst.Slug, err)
+	}
+}
+
+// ── Agent rollout ─────────────────────────────────────────────────────────────
+
+// RunMetricsAgentRollout runs the current metrics-agent.sh on running hubs
+// that haven't had it, e.g. ones launched from images baked before the
+// agent was added or changed, every interval until ctx is cancelled.
+func (h *Handler) RunMetricsAgentRollout(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		insts, err := h.store.ListMetricsAgentStale(compute.MetricsAgentVersion)
+		if err != nil {
+			fmt.Printf("metrics: list instances for agent rollout failed: %v\n", err)
+		}
+		for _, inst := range insts {
+			if err := h.compute.InstallMetricsAgent(ctx, target(inst)); err != nil {
+				fmt.Printf("metrics: agent install failed for %s: %v\n", inst.Slug, err)
+				continue
+			}
+			if err := h.store.UpdateMetricsAgent(inst.ID, compute.MetricsAgentVersion); err != nil {
+				fmt.Printf("metrics: failed to record agent on %s: %v\n", inst.Slug, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/metrics"
+)
+
+// memMetrics keeps samples and alerts in memory, with one open alert per
+// instance and metric as the partial unique index enforces.
+type memMetrics struct {
+	samples []metrics.Sample
+	open    map[string]*metrics.Alert
+	alerts  []*metrics.Alert
+}
+
+func (m *memMetrics) Record(samples []metrics.Sample) error {
+	m.samples = append(m.samples, samples...)
+	return nil
+}
+
+func (m *memMetrics) LastCollected(int64) (time.Time, error) { return time.Time{}, nil }
+
+func (m *memMetrics) Latest(int64) ([]metrics.Sample, error) { return nil, nil }
+
+func (m *memMetrics) Since(int64, string, time.Time) ([]metrics.Sample, error) { return nil, nil }
+
+func (m *memMetrics) Prune(time.Time) error { return nil }
+
+func (m *memMetrics) Raise(a *metrics.Alert) (bool, error) {
+	if m.open == nil {
+		m.open = map[string]*metrics.Alert{}
+	}
+	if _, ok := m.open[a.Metric]; ok {
+		return false, nil
+	}
+	a.ID, a.RaisedAt = int64(len(m.alerts)+1), time.Now()
+	m.open[a.Metric] = a
+	m.alerts = append(m.alerts, a)
+	return true, nil
+}
+
+func (m *memMetrics) Resolve(_ int64, metric string) (bool, error) {
+	a, ok := m.open[metric]
+	if !ok {
+		return false, nil
+	}
+	now := time.Now()
+	a.ResolvedAt = &now
+	delete(m.open, metric)
+	return true, nil
+}
+
+func (m *memMetrics) OpenAlerts(int64) ([]*metrics.Alert, error) {
+	var alerts []*metrics.Alert
+	for _, a := range m.open {
+		alerts = append(alerts, a)
+	}
+	return alerts, nil
+}
+
+// diskEmails records disk alert emails; the other notifications are unused.
+type diskEmails struct {
+	sent []float64
+}
+
+func (n *diskEmails) SendWelcome(context.Context, string, string, string) error { return nil }
+
+func (n *diskEmails) SendDataExport(context.Context, string, string, time.Duration) error {
+	return nil
+}
+
+func (n *diskEmails) SendDeletionReminder(context.Context, string, string, time.Time) error {
+	return nil
+}
+
+func (n *diskEmails) SendDiskAlert(_ context.Context, _, _ string, percent float64) error {
+	n.sent = append(n.sent, percent)
+	return nil
+}
+
+func metricsHandler(source metrics.Source) (*Handler, *memMetrics, *diskEmails) {
+	store, emails := &memMetrics{}, &diskEmails{}
+	h := &Handler{
+		cfg:          Config{DiskAlertPercent: 85},
+		metrics:      store,
+		metricSource: source,
+		notify:       emails,
+	}
+	return h, store, emails
+}
+
+var testInstance = &instance.Instance{ID: 7, Slug: "acme", Email: "ops@acme.test", EC2InstanceID: "i-0123456789"}
+
+func TestCollectInstanceRaisesDiskAlert(t *testing.T) {
+	fake := &metrics.Fake{Period: time.Minute}
+	fake.Set(metrics.Disk, 92)
+	h, store, emails := metricsHandler(fake)
+
+	h.collectInstance(context.Background(), testInstance, 10*time.Minute)
+
+	if len(store.samples) == 0 {
+		t.Fatal("no samples recorded")
+	}
+	for _, sm := range store.samples {
+		if sm.InstanceID != testInstance.ID {
+			t.Fatalf("sample recorded for instance %d, want %d", sm.InstanceID, testInstance.ID)
+		}
+	}
+	a := store.open[metrics.Disk]
+	if a == nil || a.Value != 92 || a.Threshold != 85 {
+		t.Fatalf("open disk alert = %+v, want value 92 at threshold 85", a)
+	}
+	if len(emails.sent) != 1 || emails.sent[0] != 92 {
+		t.Fatalf("disk emails = %v, want one at 92%%", emails.sent)
+	}
+}
+
+func TestCollectInstanceBelowThreshold(t *testing.T) {
+	fake := &metrics.Fake{Period: time.Minute}
+	fake.Set(metrics.Disk, 60)
+	h, store, emails := metricsHandler(fake)
+
+	h.collectInstance(context.Background(), testInstance, 10*time.Minute)
+
+	if len(store.alerts) != 0 || len(emails.sent) != 0 {
+		t.Fatalf("alerts = %v, emails = %v, want none", store.alerts, emails.sent)
+	}
+}
+
+func TestCheckDiskNoDuplicateEmail(t *testing.T) {
+	h, store, emails := metricsHandler(nil)
+	ctx := context.Background()
+
+	for _, used := range []float64{90, 93, 97} {
+		h.checkDisk(ctx, testInstance, used)
+	}
+
+	if len(store.alerts) != 1 {
+		t.Fatalf("raised %d alerts, want 1", len(store.alerts))
+	}
+	if len(emails.sent) != 1 || emails.sent[0] != 90 {
+		t.Fatalf("disk emails = %v, want one at 90%%", emails.sent)
+	}
+}
+
+func TestCheckDiskHysteresis(t *testing.T) {
+	h, store, emails := metricsHandler(nil)
+	ctx := context.Background()
+
+	h.checkDisk(ctx, testInstance, 90)
+
+	// Just under the threshold but inside the hysteresis band: still open
+	h.checkDisk(ctx, testInstance, 82)
+	if store.open[metrics.Disk] == nil {
+		t.Fatal("alert resolved within the hysteresis band")
+	}
+
+	h.checkDisk(ctx, testInstance, 85-diskAlertHysteresis-1)
+	if store.open[metrics.Disk] != nil {
+		t.Fatal("alert still open below the hysteresis band")
+	}
+	if store.alerts[0].ResolvedAt == nil {
+		t.Fatal("alert not marked resolved")
+	}
+
+	// Crossing again after resolving is a new alert and a new email
+	h.checkDisk(ctx, testInstance, 88)
+	if len(store.alerts) != 2 || len(emails.sent) != 2 {
+		t.Fatalf("alerts = %d, emails = %v, want a second alert and email", len(store.alerts), emails.sent)
+	}
+}
+
+func TestCheckDiskDisabled(t *testing.T) {
+	h, store, emails := metricsHandler(nil)
+	h.cfg.DiskAlertPercent = 0
+
+	h.checkDisk(context.Background(), testInstance, 99)
+
+	if len(store.alerts) != 0 || len(emails.sent) != 0 {
+		t.Fatalf("alerts = %v, emails = %v, want none with alerts disabled", store.alerts, emails.sent)
+	}
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/migration"
+	"github.com/adgundersen/crimata-infra/internal/plan"
+	"github.com/adgundersen/crimata-infra/internal/remediation"
+	"github.com/adgundersen/crimata-infra/internal/snapshot"
+	"github.com/go-chi/chi/v5"
+)
+
+type migrateRequest struct {
+	InstanceType string `json:"instance_type"` // defaults to the plan's
+	SubnetID     string `json:"subnet_id"`     // defaults to the region's configured subnet
+	Region       string `json:"region"`        // defaults to the hub's current region
+	Reason       string `json:"reason"`
+}
+
+// quiesceScript stops everything that writes to disk so the snapshot is
+// clean rather than merely crash-consistent.
+var quiesceScript = fmt.Sprintf("set -e\nsystemctl stop nginx %s\nsync\n", strings.Join(remediation.Units, " "))
+
+// resumeScript undoes quiesceScript on a source that stays in service.
+var resumeScript = fmt.Sprintf("set -e\nsystemctl start %s nginx\n", strings.Join(remediation.Units, " "))
+
+// migrateInstance moves a hub onto a new EC2 instance, e.g. off retiring
+// hardware, onto another instance type, into another subnet or into another
+// region. The hub is down from when its services stop until the destination
+// takes over.
+func (h *Handler) migrateInstance(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil || inst.EC2InstanceID == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var req migrateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	src := h.computeFor(inst)
+	if req.Region == "" {
+		req.Region = src.Region()
+	}
+	if !h.compute.CanLaunchIn(req.Region) {
+		http.Error(w, fmt.Sprintf("cannot migrate to %s: region not configured", req.Region), http.StatusBadRequest)
+		return
+	}
+	p, err := h.plans.Get(inst.Plan)
+	if err != nil || p == nil {
+		http.Error(w, "failed to look up plan", http.StatusInternalServerError)
+		return
+	}
+
+	current, err := src.RootVolume(r.Context(), inst.EC2InstanceID)
+	if err != nil {
+		http.Error(w, "failed to look up volume", http.StatusBadGateway)
+		return
+	}
+
+	ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive, instance.StatusDegraded}, instance.StatusMigrating)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot migrate instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	m := &migration.Migration{
+		InstanceID: inst.ID,
+		Slug:       inst.Slug,
+		Reason:     req.Reason,
+		Source:     inst.EC2InstanceID,
+		ToType:     p.InstanceType,
+		FromRegion: src.Region(),
+		ToRegion:   req.Region,
+		SubnetID:   req.SubnetID,
+	}
+	if req.InstanceType != "" {
+		m.ToType = req.InstanceType
+	}
+	if ec2, err := src.Describe(r.Context(), inst.EC2InstanceID); err == nil {
+		m.FromType = ec2.InstanceType
+	}
+	if err := h.migrations.Create(m); err != nil {
+		h.store.UpdateStatus(inst.ID, inst.Status)
+		http.Error(w, "failed to create migration", http.StatusInternalServerError)
+		return
+	}
+
+	// Encoded before the migration starts recording its progress
+	jsonResponse(w, m, http.StatusAccepted)
+	go h.runMigration(context.Background(), inst, p, current.SizeGB, m)
+}
+
+func (h *Handler) listMigrations(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	ms, err := h.migrations.ListByInstance(inst.ID)
+	if err != nil {
+		http.Error(w, "failed to list migrations", http.StatusInternalServerError)
+		return
+	}
+	if ms == nil {
+		ms = []*migration.Migration{}
+	}
+	jsonResponse(w, ms, http.StatusOK)
+}
+
+// ── Execution ─────────────────────────────────────────────────────────────────
+
+// runMigration quiesces the source, snapshots it, launches the destination
+// from the snapshot and hands over to replaceHost to verify, cut over and
+// terminate the source. On failure the source's services are restarted;
+// the migration counts as rolled back only if the source is healthy again.
+func (h *Handler) runMigration(ctx context.Context, inst *instance.Instance, p *plan.Plan, sizeGB int32, m *migration.Migration) {
+	fmt.Printf("migration: %s %s (%s, %s) -> %s in %s\n", inst.Slug, m.Source, m.FromType, m.FromRegion, m.ToType, m.ToRegion)
+	prevStatus := inst.Status
+
+	err := h.migrate(ctx, inst, p, sizeGB, m)
+	if err == nil {
+		m.Status, m.Step = migration.StatusSucceeded, migration.StepDone
+		h.store.UpdateStatus(inst.ID, instance.StatusActive)
+		fmt.Printf("migration: %s now on %s in %s\n", inst.Slug, inst.EC2InstanceID, inst.Region)
+	} else {
+		m.Error = err.Error()
+		fmt.Printf("migration: %s failed at %s: %v\n", inst.Slug, m.Step, err)
+
+		m.Status = migration.StatusRolledBack
+		if _, rerr := h.compute.Run(ctx, target(inst), resumeScript); rerr != nil {
+			m.Status = migration.StatusFailed
+			m.Error += "; restarting source services: " + rerr.Error()
+		} else if herr := h.waitHealthy(ctx, inst, 2*time.Minute); herr != nil {
+			m.Status = migration.StatusFailed
+			m.Error += "; source unhealthy after rollback: " + herr.Error()
+		}
+		h.store.UpdateStatus(inst.ID, prevStatus)
+	}
+	if err := h.migrations.Finish(m); err != nil {
+		fmt.Printf("migration: failed to record migration %d: %v\n", m.ID, err)
+	}
+}
+
+func (h *Handler) migrate(ctx context.Context, inst *instance.Instance, p *plan.Plan, sizeGB int32, m *migration.Migration) error {
+	src, dst := h.computeFor(inst), h.compute.In(m.ToRegion)
+	if _, err := h.compute.Run(ctx, target(inst), quiesceScript); err != nil {
+		return fmt.Errorf("stop services: %w", err)
+	}
+
+	h.step(m, migration.StepSnapshotting)
+	sn, err := h.takeSnapshot(ctx, inst, "migration")
+	if err != nil {
+		return err
+	}
+	m.EC2SnapshotID = sn.EC2SnapshotID
+	h.migrations.Update(m)
+	if err := src.WaitForSnapshot(ctx, sn.EC2SnapshotID, time.Hour); err != nil {
+		return fmt.Errorf("wait for snapshot: %w", err)
+	}
+	h.snapshots.Complete(sn.ID)
+
+	if dst.Region() != src.Region() {
+		h.step(m, migration.StepCopying)
+		if sn, err = h.copySnapshot(ctx, dst, sn); err != nil {
+			return err
+		}
+	}
+
+	h.step(m, migration.StepLaunching)
+	imageID, err := dst.ImageFromSnapshot(ctx, sn.EC2SnapshotID, fmt.Sprintf("crimata-migrate-%s-%d", inst.Slug, m.ID))
+	if err != nil {
+		return err
+	}
+	defer dst.DeregisterImage(ctx, imageID)
+
+	spec := compute.LaunchSpec{
+		Slug:         inst.Slug,
+		InstanceType: m.ToType,
+		ImageID:      imageID,
+		SubnetID:     m.SubnetID,
+		Volume:       volumeSpec(p),
+	}
+	spec.Volume.SizeGB = max(spec.Volume.SizeGB, sizeGB)
+	next, err := dst.Launch(ctx, spec)
+	if err != nil {
+		return err
+	}
+	m.Destination = next.InstanceID
+
+	h.step(m, migration.StepSwitching)
+	return h.replaceHost(ctx, inst, next)
+}
+
+// copySnapshot copies a completed snapshot into dst's region and waits for
+// the copy, which is kept as the hub's first snapshot there.
+func (h *Handler) copySnapshot(ctx context.Context, dst *compute.Client, sn *snapshot.Snapshot) (*snapshot.Snapshot, error) {
+	copyID, err := dst.CopySnapshot(ctx, sn.EC2SnapshotID, h.compute.In(sn.Region).Region(), sn.Slug)
+	if err != nil {
+		return nil, err
+	}
+	cp := &snapshot.Snapshot{
+		InstanceID:    sn.InstanceID,
+		Slug:          sn.Slug,
+		EC2SnapshotID: copyID,
+		Region:        dst.Region(),
+		VolumeID:      sn.VolumeID,
+		SizeGB:        sn.SizeGB,
+		Trigger:       sn.Trigger,
+	}
+	if err := h.recordSnapshot(ctx, dst, cp); err != nil {
+		return nil, err
+	}
+	if err := dst.WaitForSnapshot(ctx, copyID, time.Hour); err != nil {
+		return nil, fmt.Errorf("wait for snapshot copy: %w", err)
+	}
+	h.snapshots.Complete(cp.ID)
+	return cp, nil
+}
+
+// RecoverMigrations cleans up after migrations whose orchestrator died with
+// the previous process. The source's services are restarted, a destination
+// the hub never moved onto is terminated, the migration is marked failed
+// and the instance returned to active for the health prober to judge. Call
+// it once at startup, before serving.
+func (h *Handler) RecoverMigrations() error {
+	running, err := h.migrations.ListRunning()
+	if err != nil {
+		return err
+	}
+	for _, m := range running {
+		inst, err := h.store.Get(m.InstanceID)
+		if err != nil {
+			return err
+		}
+		if inst != nil {
+			h.recoverMigration(inst, m)
+		}
+
+		m.Status, m.Error = migration.StatusFailed, "interrupted by restart"
+		if err := h.migrations.Finish(m); err != nil {
+			return err
+		}
+		fmt.Printf("migration %d: interrupted by restart at %s, failed\n", m.ID, m.Step)
+	}
+
+	stuck, err := h.store.ListByStatus(instance.StatusMigrating)
+	if err != nil {
+		return err
+	}
+	for _, inst := range stuck {
+		if _, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusMigrating}, instance.StatusActive); err != nil {
+			return err
+		}
+		fmt.Printf("migration: %s was left migrating, back to active\n", inst.Slug)
+	}
+	return nil
+}
+
+// recoverMigration brings the hub's current host back into service and
+// terminates whichever of source and destination it isn't on; replaceHost
+// may have cut over before the restart.
+func (h *Handler) recoverMigration(inst *instance.Instance, m *migration.Migration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if _, err := h.compute.Run(ctx, target(inst), resumeScript); err != nil {
+		fmt.Printf("migration: restarting services on %s failed: %v\n", inst.Slug, err)
+	}
+	orphan, region := m.Destination, m.ToRegion
+	if inst.EC2InstanceID == m.Destination {
+		orphan, region = m.Source, m.FromRegion
+	}
+	if orphan == "" {
+		return
+	}
+	if err := h.compute.In(region).Terminate(ctx, orphan); err != nil {
+		fmt.Printf("migration: terminate %s of %s failed: %v\n", orphan, inst.Slug, err)
+	}
+}
+
+func (h *Handler) step(m *migration.Migration, step string) {
+	m.Step = step
+	if err := h.migrations.Update(m); err != nil {
+		fmt.Printf("migration: failed to record step of %d: %v\n", m.ID, err)
+	}
+}
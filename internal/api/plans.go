@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/plan"
+	"github.com/go-chi/chi/v5"
+)
+
+type resizeRequest struct {
+	Plan string `json:"plan"`
+}
+
+func (h *Handler) listPlans(w http.ResponseWriter, r *http.Request) {
+	plans, err := h.plans.List()
+	if err != nil {
+		http.Error(w, "failed to list plans", http.StatusInternalServerError)
+		return
+	}
+	if plans == nil {
+		plans = []*plan.Plan{}
+	}
+	jsonResponse(w, plans, http.StatusOK)
+}
+
+func (h *Handler) putPlan(w http.ResponseWriter, r *http.Request) {
+	var p plan.Plan
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	p.Name = chi.URLParam(r, "name")
+	if p.InstanceType == "" {
+		http.Error(w, "instance_type is required", http.StatusBadRequest)
+		return
+	}
+	if p.WarmPoolSize < 0 {
+		http.Error(w, "warm_pool_size must not be negative", http.StatusBadRequest)
+		return
+	}
+	if p.DiskSizeGB < 0 || p.DiskIOPS < 0 {
+		http.Error(w, "disk_size_gb and disk_iops must not be negative", http.StatusBadRequest)
+		return
+	}
+	if p.Features == nil {
+		p.Features = []string{}
+	}
+	if err := h.plans.Upsert(&p); err != nil {
+		http.Error(w, "failed to save plan", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, p, http.StatusOK)
+}
+
+// volumeSpec is the root volume instances of a plan launch with.
+func volumeSpec(p *plan.Plan) compute.VolumeSpec {
+	return compute.VolumeSpec{
+		SizeGB:    p.DiskSizeGB,
+		Type:      p.DiskType,
+		IOPS:      p.DiskIOPS,
+		Encrypted: p.DiskEncrypted,
+	}
+}
+
+// resolvePlan maps a create request to a plan: an explicit plan name first,
+// then the Stripe price, then the default. Returns nil if none match.
+func (h *Handler) resolvePlan(req createRequest) (*plan.Plan, error) {
+	switch {
+	case req.Plan != "":
+		return h.plans.Get(req.Plan)
+	case req.StripePriceID != "":
+		return h.plans.GetByStripePrice(req.StripePriceID)
+	default:
+		return h.plans.Get(h.cfg.DefaultPlan)
+	}
+}
+
+func (h *Handler) resizeInstance(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var req resizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	p, err := h.plans.Get(req.Plan)
+	if err != nil {
+		http.Error(w, "failed to look up plan", http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		http.Error(w, "unknown plan", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive, instance.StatusDegraded}, instance.StatusResizing)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot resize instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	go func() {
+		if err := h.resize(context.Background(), inst, p); err != nil {
+			fmt.Printf("resize: %s: %v\n", inst.Slug, err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// resize moves an instance onto a plan's instance type. compute.Resize rolls
+// back to the old type on failure; either way, DNS follows the instance's new
+// public IP once it is running again. The instance is marked failed only if
+// it could not be brought back up.
+func (h *Handler) resize(ctx context.Context, inst *instance.Instance, p *plan.Plan) error {
+	ec2, resizeErr := h.computeFor(inst).Resize(ctx, inst.EC2InstanceID, p.InstanceType)
+	if ec2 == nil {
+		// Only a failed rollback or restart leaves the instance down
+		status := instance.StatusFailed
+		if errors.Is(resizeErr, compute.ErrUnchanged) {
+			status = inst.Status
+		}
+		h.store.UpdateStatus(inst.ID, status)
+		return resizeErr
+	}
+
+	if ec2.PublicIP != inst.EC2PublicIP || ec2.IPv6 != inst.EC2IPv6 {
+		h.store.UpdateAddresses(inst.ID, ec2.PublicIP, ec2.PrivateIP, ec2.IPv6)
+		if err := h.dns.UpdateRecord(ctx, inst.Slug, ec2.PublicIP, ec2.IPv6); err != nil {
+			fmt.Printf("resize: dns update failed for %s: %v\n", inst.Slug, err)
+		}
+	}
+
+	if resizeErr != nil {
+		// Back on the old type, as it was before
+		h.store.UpdateStatus(inst.ID, inst.Status)
+		return resizeErr
+	}
+	h.store.UpdateStatus(inst.ID, instance.StatusActive)
+
+	h.store.UpdatePlan(inst.ID, p.Name)
+	fmt.Printf("resize: %s is now on %s (%s)\n", inst.Slug, p.Name, p.InstanceType)
+
+	// Plans with a bigger disk grow the volume too; volumes never shrink
+	if p.DiskSizeGB > 0 {
+		vol, err := h.computeFor(inst).RootVolume(ctx, inst.EC2InstanceID)
+		if err == nil && p.DiskSizeGB > vol.SizeGB {
+			err = h.expand(ctx, inst, vol, p.DiskSizeGB)
+		}
+		if err != nil {
+			fmt.Printf("resize: volume expansion failed for %s: %v\n", inst.Slug, err)
+		}
+	}
+	return nil
+}
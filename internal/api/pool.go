@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/plan"
+	"github.com/adgundersen/crimata-infra/internal/pool"
+)
+
+// warmTimeout is how long a pool instance may stay warming before it is
+// assumed lost, e.g. to a restart mid-install, and replaced.
+const warmTimeout = time.Hour
+
+// acquireInstance hands a new customer an EC2 instance: a warm one from the
+// plan's pool if available, otherwise a fresh launch. baked reports whether
+// the base install is already done.
+func (h *Handler) acquireInstance(ctx context.Context, slug string, p *plan.Plan) (ec2 *compute.Instance, baked bool, err error) {
+	warm, err := h.pool.Claim(poolSpec(p))
+	if err != nil {
+		fmt.Printf("provision: pool claim failed for %s, launching: %v\n", slug, err)
+	}
+	if warm != nil {
+		if err := h.compute.Rename(ctx, warm.EC2InstanceID, slug); err != nil {
+			fmt.Printf("provision: rename %s failed for %s: %v\n", warm.EC2InstanceID, slug, err)
+		}
+		fmt.Printf("provision: %s claimed warm instance %s\n", slug, warm.EC2InstanceID)
+		return &compute.Instance{
+			InstanceID:    warm.EC2InstanceID,
+			InstanceType:  warm.InstanceType,
+			SSHPrivateKey: warm.SSHPrivateKey,
+		}, true, nil
+	}
+	return h.launch(ctx, slug, p)
+}
+
+// poolSpec is what a warm instance of a plan must match to be claimed: its
+// instance type and root volume.
+func poolSpec(p *plan.Plan) *pool.Instance {
+	return &pool.Instance{
+		Plan:          p.Name,
+		InstanceType:  p.InstanceType,
+		DiskSizeGB:    p.DiskSizeGB,
+		DiskType:      p.DiskType,
+		DiskIOPS:      p.DiskIOPS,
+		DiskEncrypted: p.DiskEncrypted,
+	}
+}
+
+// launch starts an instance of a plan from the latest golden image, falling
+// back to the base AMI when none has been built.
+func (h *Handler) launch(ctx context.Context, slug string, p *plan.Plan) (*compute.Instance, bool, error) {
+	spec := compute.LaunchSpec{Slug: slug, InstanceType: p.InstanceType, Volume: volumeSpec(p)}
+	img, err := h.images.Latest()
+	if err != nil {
+		fmt.Printf("launch: image lookup failed for %s, using base AMI: %v\n", slug, err)
+	}
+	if img != nil {
+		spec.ImageID = img.AMIID
+	}
+	ec2, err := h.compute.Launch(ctx, spec)
+	return ec2, img != nil, err
+}
+
+// ── Replenishing ──────────────────────────────────────────────────────────────
+
+// RunPoolReplenisher keeps each plan's warm pool at its configured size
+// until ctx is cancelled.
+func (h *Handler) RunPoolReplenisher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.replenishPools(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) replenishPools(ctx context.Context) {
+	plans, err := h.plans.List()
+	if err != nil {
+		fmt.Printf("pool: list plans failed: %v\n", err)
+		return
+	}
+	for _, p := range plans {
+		h.replenishPool(ctx, p)
+	}
+}
+
+// replenishPool retires lost and outdated instances, trims surplus ready
+// ones and starts warming enough new ones to reach the plan's pool size.
+func (h *Handler) replenishPool(ctx context.Context, p *plan.Plan) {
+	insts, err := h.pool.ListByPlan(p.Name)
+	if err != nil {
+		fmt.Printf("pool: list %s failed: %v\n", p.Name, err)
+		return
+	}
+
+	// Instances launch from the latest golden image, or the base AMI
+	image := h.compute.BaseAMI()
+	if img, err := h.images.Latest(); err != nil {
+		fmt.Printf("pool: image lookup failed, keeping %s instances: %v\n", p.Name, err)
+		image = ""
+	} else if img != nil {
+		image = img.AMIID
+	}
+
+	spec := poolSpec(p)
+	var live []*pool.Instance
+	for _, inst := range insts {
+		switch {
+		case inst.Status == pool.StatusWarming && time.Since(inst.CreatedAt) > warmTimeout:
+			fmt.Printf("pool: %s instance %d stuck warming, retiring\n", p.Name, inst.ID)
+			h.retire(ctx, inst)
+		case inst.Status == pool.StatusReady && !inst.Matches(spec):
+			// The plan's instance type or volume changed since it launched
+			h.retire(ctx, inst)
+		case inst.Status == pool.StatusReady && image != "" && inst.ImageID != image:
+			fmt.Printf("pool: %s instance %d predates image %s, retiring\n", p.Name, inst.ID, image)
+			h.retire(ctx, inst)
+		default:
+			live = append(live, inst)
+		}
+	}
+
+	// Trim surplus newest-first, leaving warming instances to finish
+	for i := len(live) - 1; i >= 0 && len(live) > p.WarmPoolSize; i-- {
+		if live[i].Status == pool.StatusReady {
+			h.retire(ctx, live[i])
+			live = append(live[:i], live[i+1:]...)
+		}
+	}
+
+	for n := len(live); n < p.WarmPoolSize; n++ {
+		inst := poolSpec(p)
+		if err := h.pool.Create(inst); err != nil {
+			fmt.Printf("pool: reserve %s slot failed: %v\n", p.Name, err)
+			return
+		}
+		go h.warm(context.Background(), inst, p)
+	}
+}
+
+// warm launches and pre-provisions a pool instance with everything but the
+// customer's user, secrets and hostname.
+func (h *Handler) warm(ctx context.Context, inst *pool.Instance, p *plan.Plan) {
+	ec2, baked, err := h.launch(ctx, fmt.Sprintf("pool-%s-%d", inst.Plan, inst.ID), p)
+	if err != nil {
+		fmt.Printf("pool: launch failed for %s instance %d: %v\n", inst.Plan, inst.ID, err)
+		h.pool.Remove(inst.ID)
+		return
+	}
+	inst.EC2InstanceID, inst.SSHPrivateKey, inst.ImageID = ec2.InstanceID, ec2.SSHPrivateKey, ec2.ImageID
+	if recorded, err := h.pool.UpdateEC2(inst.ID, ec2.InstanceID, ec2.SSHPrivateKey, ec2.ImageID); err != nil || !recorded {
+		// Retired while launching; nothing else knows about this instance
+		fmt.Printf("pool: %s instance %d gone before launch was recorded, terminating %s\n", inst.Plan, inst.ID, ec2.InstanceID)
+		h.pool.Remove(inst.ID)
+		h.compute.Terminate(ctx, ec2.InstanceID)
+		return
+	}
+
+	ready, err := h.compute.WaitUntilReady(ctx, ec2.InstanceID)
+	if err != nil {
+		fmt.Printf("pool: wait failed for %s: %v\n", ec2.InstanceID, err)
+		h.retire(ctx, inst)
+		return
+	}
+	if !baked {
+		if err := h.compute.Prepare(ctx, ready.Host(), ec2.SSHPrivateKey); err != nil {
+			fmt.Printf("pool: prepare failed for %s: %v\n", ec2.InstanceID, err)
+			h.retire(ctx, inst)
+			return
+		}
+	}
+
+	h.pool.MarkReady(inst.ID)
+	fmt.Printf("pool: %s instance %s ready\n", inst.Plan, ec2.InstanceID)
+}
+
+// retire removes a pool instance and terminates it. The row goes first so
+// an instance claimed concurrently is never terminated under a customer.
+func (h *Handler) retire(ctx context.Context, inst *pool.Instance) {
+	removed, err := h.pool.Remove(inst.ID)
+	if err != nil || !removed {
+		return
+	}
+	if inst.EC2InstanceID != "" {
+		if err := h.compute.Terminate(ctx, inst.EC2InstanceID); err != nil {
+			fmt.Printf("pool: terminate %s failed: %v\n", inst.EC2InstanceID, err)
+		}
+	}
+}
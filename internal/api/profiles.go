@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/profile"
+	"github.com/go-chi/chi/v5"
+)
+
+var paramNameRe = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+type rolloutRequest struct {
+	Percent int `json:"percent"`
+}
+
+func (h *Handler) listProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := h.profiles.List(chi.URLParam(r, "name"))
+	if err != nil {
+		http.Error(w, "failed to list profiles", http.StatusInternalServerError)
+		return
+	}
+	if profiles == nil {
+		profiles = []*profile.Profile{}
+	}
+	jsonResponse(w, profiles, http.StatusOK)
+}
+
+// createProfile stores a new version of a profile. It starts at the rollout
+// percentage given, 0 by default, so it can be raised gradually.
+func (h *Handler) createProfile(w http.ResponseWriter, r *http.Request) {
+	var p profile.Profile
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	p.Name = chi.URLParam(r, "name")
+	if strings.TrimSpace(p.Script) == "" {
+		http.Error(w, "script is required", http.StatusBadRequest)
+		return
+	}
+	if p.RolloutPercent < 0 || p.RolloutPercent > 100 {
+		http.Error(w, "rollout_percent must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+	for k := range p.Params {
+		if !paramNameRe.MatchString(k) {
+			http.Error(w, "invalid param name: "+k, http.StatusBadRequest)
+			return
+		}
+	}
+	if p.Params == nil {
+		p.Params = map[string]string{}
+	}
+	if len(p.Export) == 0 {
+		p.Export = profile.DefaultExport
+	}
+	for _, dir := range p.Export {
+		if !validExportDir(dir) {
+			http.Error(w, "invalid export directory: "+dir, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.profiles.Create(&p); err != nil {
+		http.Error(w, "failed to save profile", http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, p, http.StatusCreated)
+}
+
+func (h *Handler) setProfileRollout(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	var req rolloutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Percent < 0 || req.Percent > 100 {
+		http.Error(w, "percent must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+
+	ok, err := h.profiles.SetRollout(chi.URLParam(r, "name"), version, req.Percent)
+	if err != nil {
+		http.Error(w, "failed to update rollout", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validExportDir accepts plain relative paths under /opt/crimata, which
+// export.sh receives unquoted.
+func validExportDir(dir string) bool {
+	return dir != "" && dir != "." && dir == path.Clean(dir) && !path.IsAbs(dir) &&
+		!strings.HasPrefix(dir, "..") && !strings.ContainsAny(dir, " \t\n'\"$`\\;&|<>*?()")
+}
+
+// exportDirs returns the directories an instance's profile exports.
+func (h *Handler) exportDirs(inst *instance.Instance) []string {
+	if inst.Profile == "" {
+		return profile.DefaultExport
+	}
+	p, err := h.profiles.Get(inst.Profile, inst.ProfileVersion)
+	if err != nil || p == nil || len(p.Export) == 0 {
+		return profile.DefaultExport
+	}
+	return p.Export
+}
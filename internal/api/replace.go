@@ -0,0 +1,104 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+)
+
+// replaceHost moves a hub onto a freshly launched EC2 instance that already
+// holds its data, possibly in another region. The replacement must pass its
+// health checks, probed directly, before anything points at it, and again
+// once the Elastic IP or DNS record has moved over. If either check fails
+// the hub is pointed back at its current host and the replacement
+// terminated; otherwise the instance record follows and the old host is
+// terminated.
+func (h *Handler) replaceHost(ctx context.Context, inst *instance.Instance, next *compute.Instance) error {
+	src, dst := h.computeFor(inst), h.compute.In(next.Region)
+	ready, err := dst.WaitUntilReady(ctx, next.InstanceID)
+	if err != nil {
+		dst.Terminate(ctx, next.InstanceID)
+		return fmt.Errorf("wait for replacement: %w", err)
+	}
+
+	candidate := *inst
+	candidate.EC2InstanceID, candidate.SSHPrivateKey = ready.InstanceID, next.SSHPrivateKey
+	candidate.EC2PublicIP, candidate.EC2PrivateIP, candidate.EC2IPv6 = ready.PublicIP, ready.PrivateIP, ready.IPv6
+	candidate.Region = dst.Region()
+	candidate.HealthFailures = 0
+	if err := h.waitHealthy(ctx, &candidate, 5*time.Minute); err != nil {
+		dst.Terminate(ctx, next.InstanceID)
+		return fmt.Errorf("replacement unhealthy: %w", err)
+	}
+
+	// An Elastic IP can't leave its region, so a hub that moves gets a new one
+	switch {
+	case inst.EIPAllocationID == "":
+	case dst.Region() == src.Region():
+		candidate.EC2PublicIP = inst.EC2PublicIP
+	case dst.ElasticIPEnabled():
+		allocationID, ip, err := dst.AssignElasticIP(ctx, inst.Slug, next.InstanceID)
+		if err != nil {
+			dst.Terminate(ctx, next.InstanceID)
+			return fmt.Errorf("elastic ip: %w", err)
+		}
+		candidate.EIPAllocationID, candidate.EC2PublicIP = allocationID, ip
+	default:
+		candidate.EIPAllocationID = ""
+	}
+	if err := h.pointAt(ctx, &candidate); err != nil {
+		h.rollBackHost(ctx, inst, &candidate)
+		return fmt.Errorf("cut over: %w", err)
+	}
+	if err := h.waitHealthy(ctx, &candidate, time.Minute); err != nil {
+		h.rollBackHost(ctx, inst, &candidate)
+		return fmt.Errorf("replacement unhealthy after cutover: %w", err)
+	}
+
+	h.store.UpdateEC2(inst.ID, candidate.EC2InstanceID, candidate.EC2PublicIP)
+	h.store.UpdateAddresses(inst.ID, candidate.EC2PublicIP, candidate.EC2PrivateIP, candidate.EC2IPv6)
+	h.store.UpdateElasticIP(inst.ID, candidate.EIPAllocationID, candidate.EC2PublicIP)
+	h.store.UpdateRegion(inst.ID, candidate.Region)
+	h.store.UpdateSSHKey(inst.ID, candidate.SSHPrivateKey)
+	h.store.UpdateHealthFailures(inst.ID, 0)
+
+	if err := src.Terminate(ctx, inst.EC2InstanceID); err != nil {
+		fmt.Printf("replace: terminate old host %s of %s failed: %v\n", inst.EC2InstanceID, inst.Slug, err)
+	}
+	if inst.EIPAllocationID != "" && inst.EIPAllocationID != candidate.EIPAllocationID {
+		if err := src.ReleaseElasticIP(ctx, inst.EIPAllocationID); err != nil {
+			fmt.Printf("replace: elastic ip release failed for %s: %v\n", inst.Slug, err)
+		}
+	}
+	*inst = candidate
+	return nil
+}
+
+// pointAt moves the hub's Elastic IP, if it has one, and DNS records to
+// inst's EC2 instance and addresses.
+func (h *Handler) pointAt(ctx context.Context, inst *instance.Instance) error {
+	if inst.EIPAllocationID != "" {
+		if err := h.computeFor(inst).MoveElasticIP(ctx, inst.EIPAllocationID, inst.EC2InstanceID); err != nil {
+			return err
+		}
+	}
+	return h.dns.UpdateRecord(ctx, inst.Slug, inst.EC2PublicIP, inst.EC2IPv6)
+}
+
+// rollBackHost points the hub back at its current host and terminates the
+// replacement, releasing any Elastic IP allocated for it.
+func (h *Handler) rollBackHost(ctx context.Context, inst, replacement *instance.Instance) {
+	if err := h.pointAt(ctx, inst); err != nil {
+		fmt.Printf("replace: pointing %s back at %s failed: %v\n", inst.Slug, inst.EC2InstanceID, err)
+	}
+	c := h.computeFor(replacement)
+	c.Terminate(ctx, replacement.EC2InstanceID)
+	if replacement.EIPAllocationID != "" && replacement.EIPAllocationID != inst.EIPAllocationID {
+		if err := c.ReleaseElasticIP(ctx, replacement.EIPAllocationID); err != nil {
+			fmt.Printf("replace: elastic ip release failed for %s: %v\n", inst.Slug, err)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/plan"
+	"github.com/adgundersen/crimata-infra/internal/snapshot"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil || inst.EC2InstanceID == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	switch inst.Status {
+	case instance.StatusActive, instance.StatusDegraded, instance.StatusSuspended, instance.StatusPendingDeletion:
+	default:
+		http.Error(w, fmt.Sprintf("cannot snapshot instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	sn, err := h.takeSnapshot(r.Context(), inst, "manual")
+	if err != nil {
+		fmt.Printf("snapshot: %s: %v\n", inst.Slug, err)
+		http.Error(w, "failed to create snapshot", http.StatusBadGateway)
+		return
+	}
+	jsonResponse(w, sn, http.StatusAccepted)
+}
+
+func (h *Handler) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	snaps, err := h.snapshots.ListByInstance(inst.ID)
+	if err != nil {
+		http.Error(w, "failed to list snapshots", http.StatusInternalServerError)
+		return
+	}
+	if snaps == nil {
+		snaps = []*snapshot.Snapshot{}
+	}
+	jsonResponse(w, snaps, http.StatusOK)
+}
+
+// restoreSnapshot replaces a hub's EC2 instance with one launched from a
+// completed snapshot of it, e.g. after its data was corrupted. The current
+// host keeps serving until the replacement is healthy.
+func (h *Handler) restoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	sn, err := h.snapshots.Get(id)
+	if err != nil || sn == nil || sn.InstanceID != inst.ID {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if sn.Status != snapshot.StatusCompleted {
+		http.Error(w, fmt.Sprintf("cannot restore snapshot in status %q", sn.Status), http.StatusConflict)
+		return
+	}
+	// Snapshots taken before a hub moved region stay behind in the old one
+	if from, to := h.compute.In(sn.Region).Region(), h.computeFor(inst).Region(); from != to {
+		http.Error(w, fmt.Sprintf("cannot restore snapshot in %s to instance in %s", from, to), http.StatusConflict)
+		return
+	}
+	p, err := h.plans.Get(inst.Plan)
+	if err != nil || p == nil {
+		http.Error(w, "failed to look up plan", http.StatusInternalServerError)
+		return
+	}
+
+	from := []instance.Status{instance.StatusActive, instance.StatusDegraded, instance.StatusFailed}
+	ok, err := h.store.TransitionStatus(inst.ID, from, instance.StatusRestoring)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot restore instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+	// RecoverRestores puts the hub back in this status if the restart
+	// interrupts the restore
+	if err := h.store.UpdateReplacement(inst.ID, "", inst.Status); err != nil {
+		h.store.UpdateStatus(inst.ID, inst.Status)
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+
+	go func() {
+		err := h.restore(context.Background(), inst, p, sn)
+		if err := h.store.UpdateReplacement(inst.ID, "", ""); err != nil {
+			fmt.Printf("restore: failed to clear replacement of %s: %v\n", inst.Slug, err)
+		}
+		if err != nil {
+			fmt.Printf("restore: %s from %s failed: %v\n", inst.Slug, sn.EC2SnapshotID, err)
+			h.store.UpdateStatus(inst.ID, inst.Status)
+			return
+		}
+		h.store.UpdateStatus(inst.ID, instance.StatusActive)
+		fmt.Printf("restore: %s restored from %s\n", inst.Slug, sn.EC2SnapshotID)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// restore launches an instance of the hub's plan from a snapshot and moves
+// the hub onto it.
+func (h *Handler) restore(ctx context.Context, inst *instance.Instance, p *plan.Plan, sn *snapshot.Snapshot) error {
+	c := h.computeFor(inst)
+	imageID, err := c.ImageFromSnapshot(ctx, sn.EC2SnapshotID, fmt.Sprintf("crimata-restore-%s-%d", inst.Slug, time.Now().Unix()))
+	if err != nil {
+		return err
+	}
+	// Instances keep running once launched; the image is only needed for that
+	defer c.DeregisterImage(ctx, imageID)
+
+	spec := compute.LaunchSpec{Slug: inst.Slug, InstanceType: p.InstanceType, ImageID: imageID, Volume: volumeSpec(p)}
+	spec.Volume.SizeGB = max(spec.Volume.SizeGB, sn.SizeGB)
+	next, err := c.Launch(ctx, spec)
+	if err != nil {
+		return err
+	}
+	if err := h.store.UpdateReplacement(inst.ID, next.InstanceID, inst.Status); err != nil {
+		c.Terminate(ctx, next.InstanceID)
+		return fmt.Errorf("record replacement: %w", err)
+	}
+	return h.replaceHost(ctx, inst, next)
+}
+
+// RecoverRestores finishes restores a restart interrupted: the replacement
+// is terminated unless the hub already moved onto it, the hub is pointed
+// back at its current host, and it returns to the status it had before.
+func (h *Handler) RecoverRestores() error {
+	stuck, err := h.store.ListByStatus(instance.StatusRestoring)
+	if err != nil {
+		return err
+	}
+	for _, inst := range stuck {
+		to := inst.PriorStatus
+		switch {
+		case inst.ReplacementID == "":
+		case inst.ReplacementID == inst.EC2InstanceID:
+			// replaceHost cut over before the restart
+			to = instance.StatusActive
+		default:
+			h.abandonRestore(inst)
+		}
+		if to == "" {
+			to = instance.StatusActive
+		}
+
+		if _, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusRestoring}, to); err != nil {
+			return err
+		}
+		if err := h.store.UpdateReplacement(inst.ID, "", ""); err != nil {
+			return err
+		}
+		fmt.Printf("restore: %s was left restoring, back to %s\n", inst.Slug, to)
+	}
+	return nil
+}
+
+// abandonRestore terminates the replacement of an interrupted restore, after
+// pointing the hub back at its current host in case replaceHost had begun
+// moving it.
+func (h *Handler) abandonRestore(inst *instance.Instance) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := h.pointAt(ctx, inst); err != nil {
+		fmt.Printf("restore: pointing %s back at %s failed: %v\n", inst.Slug, inst.EC2InstanceID, err)
+	}
+	if err := h.computeFor(inst).Terminate(ctx, inst.ReplacementID); err != nil {
+		fmt.Printf("restore: terminate %s of %s failed: %v\n", inst.ReplacementID, inst.Slug, err)
+	}
+}
+
+// takeSnapshot starts a snapshot of an instance's root volume. It completes
+// in the background; the scheduler records when.
+func (h *Handler) takeSnapshot(ctx context.Context, inst *instance.Instance, trigger string) (*snapshot.Snapshot, error) {
+	c := h.computeFor(inst)
+	vol, err := c.RootVolume(ctx, inst.EC2InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	snapshotID, err := c.CreateSnapshot(ctx, vol.VolumeID, inst.Slug)
+	if err != nil {
+		return nil, err
+	}
+
+	sn := &snapshot.Snapshot{
+		InstanceID:    inst.ID,
+		Slug:          inst.Slug,
+		EC2SnapshotID: snapshotID,
+		Region:        c.Region(),
+		VolumeID:      vol.VolumeID,
+		SizeGB:        vol.SizeGB,
+		Trigger:       trigger,
+	}
+	if err := h.recordSnapshot(ctx, c, sn); err != nil {
+		return nil, err
+	}
+	fmt.Printf("snapshot: %s started %s (%s)\n", inst.Slug, snapshotID, trigger)
+	return sn, nil
+}
+
+// recordSnapshot records a snapshot just started through c, deleting it if
+// it can't be recorded: an untracked snapshot would never expire.
+func (h *Handler) recordSnapshot(ctx context.Context, c *compute.Client, sn *snapshot.Snapshot) error {
+	if err := h.snapshots.Create(sn); err != nil {
+		c.DeleteSnapshot(ctx, sn.EC2SnapshotID)
+		return fmt.Errorf("record snapshot %s: %w", sn.EC2SnapshotID, err)
+	}
+	return nil
+}
+
+// ── Scheduler ─────────────────────────────────────────────────────────────────
+
+// RunSnapshotScheduler snapshots each hub once per cfg.SnapshotEvery, if
+// set, tracks pending snapshots to completion and deletes expired ones, checking
+// on each tick until ctx is cancelled.
+func (h *Handler) RunSnapshotScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.processSnapshots(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) processSnapshots(ctx context.Context) {
+	h.checkPendingSnapshots(ctx)
+	defer h.expireSnapshots(ctx)
+	if h.cfg.SnapshotEvery <= 0 {
+		return
+	}
+
+	insts, err := h.store.ListByStatus(instance.StatusActive, instance.StatusDegraded, instance.StatusSuspended, instance.StatusPendingDeletion)
+	if err != nil {
+		fmt.Printf("snapshot: list instances failed: %v\n", err)
+		return
+	}
+	for _, inst := range insts {
+		if inst.EC2InstanceID == "" {
+			continue
+		}
+		last, err := h.snapshots.LastCreated(inst.ID)
+		if err != nil || time.Since(last) < h.cfg.SnapshotEvery {
+			continue
+		}
+		if _, err := h.takeSnapshot(ctx, inst, "scheduled"); err != nil {
+			fmt.Printf("snapshot: %s: %v\n", inst.Slug, err)
+		}
+	}
+}
+
+func (h *Handler) checkPendingSnapshots(ctx context.Context) {
+	pending, err := h.snapshots.ListPending()
+	if err != nil {
+		fmt.Printf("snapshot: list pending failed: %v\n", err)
+		return
+	}
+	for _, sn := range pending {
+		c := h.compute.In(sn.Region)
+		state, err := c.SnapshotState(ctx, sn.EC2SnapshotID)
+		switch {
+		case err != nil:
+			fmt.Printf("snapshot: check %s failed: %v\n", sn.EC2SnapshotID, err)
+		case state == "completed":
+			h.snapshots.Complete(sn.ID)
+		case state == "error":
+			h.snapshots.Fail(sn.ID, "snapshot failed in EC2")
+			c.DeleteSnapshot(ctx, sn.EC2SnapshotID)
+		}
+	}
+}
+
+func (h *Handler) expireSnapshots(ctx context.Context) {
+	// A hub whose snapshots have been failing must not lose its last good one
+	expired, err := h.snapshots.ListExpired(time.Now().Add(-h.cfg.SnapshotRetention), max(h.cfg.SnapshotKeep, 1))
+	if err != nil {
+		fmt.Printf("snapshot: list expired failed: %v\n", err)
+		return
+	}
+	for _, sn := range expired {
+		if err := h.compute.In(sn.Region).DeleteSnapshot(ctx, sn.EC2SnapshotID); err != nil {
+			fmt.Printf("snapshot: delete %s failed: %v\n", sn.EC2SnapshotID, err)
+			continue
+		}
+		h.snapshots.MarkDeleted(sn.ID)
+	}
+}
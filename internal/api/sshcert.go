@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/audit"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/sshca"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+type sshCertRequest struct {
+	PublicKey string `json:"public_key"` // authorized_keys format
+	TTL       string `json:"ttl"`        // Go duration, e.g. "15m"; empty uses the default
+}
+
+type sshCertResponse struct {
+	Certificate string    `json:"certificate"` // save as <key>-cert.pub
+	Serial      uint64    `json:"serial"`
+	KeyID       string    `json:"key_id"`
+	Principals  []string  `json:"principals"`
+	User        string    `json:"user"` // log in as this user
+	Host        string    `json:"host"`
+	ValidBefore time.Time `json:"valid_before"`
+}
+
+// issueSSHCert signs an operator's public key for short-lived access to an
+// instance. The certificate names only that instance's principal, so it is
+// refused by every other hub. Every certificate is recorded in the audit log.
+func (h *Handler) issueSSHCert(w http.ResponseWriter, r *http.Request) {
+	if h.ca == nil {
+		http.Error(w, "ssh certificate authority is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	operator := strings.TrimSpace(r.Header.Get(operatorHeader))
+	if operator == "" {
+		http.Error(w, operatorHeader+" header is required", http.StatusBadRequest)
+		return
+	}
+
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var req sshCertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PublicKey == "" {
+		http.Error(w, "public_key is required", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+
+	keyID := fmt.Sprintf("%s@%s", operator, inst.Slug)
+	entry := &audit.Entry{InstanceID: inst.ID, Operator: operator, Action: "ssh-cert", Detail: keyID}
+	if err := h.audit.Start(entry); err != nil {
+		http.Error(w, "failed to write audit log", http.StatusInternalServerError)
+		return
+	}
+
+	cert, err := h.ca.Sign(sshca.Request{
+		PublicKey: req.PublicKey,
+		KeyID:     keyID,
+		Slug:      inst.Slug,
+		TTL:       ttl,
+	})
+	if err != nil {
+		h.audit.Finish(entry, "", err)
+		http.Error(w, "failed to sign certificate", http.StatusBadRequest)
+		return
+	}
+
+	resp := sshCertResponse{
+		Certificate: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))),
+		Serial:      cert.Serial,
+		KeyID:       cert.KeyId,
+		Principals:  cert.ValidPrincipals,
+		User:        sshca.LoginUser,
+		Host:        target(inst).Host,
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0).UTC(),
+	}
+	summary := fmt.Sprintf("serial=%d principals=%s fingerprint=%s valid_before=%s",
+		cert.Serial, strings.Join(cert.ValidPrincipals, ","), ssh.FingerprintSHA256(cert.Key), resp.ValidBefore.Format(time.RFC3339))
+	if err := h.audit.Finish(entry, summary, nil); err != nil {
+		fmt.Printf("ssh-cert: failed to record audit entry %d: %v\n", entry.ID, err)
+	}
+	fmt.Printf("ssh-cert: issued %s to %s (%s)\n", keyID, operator, summary)
+
+	jsonResponse(w, resp, http.StatusOK)
+}
+
+// ── CA rollout ────────────────────────────────────────────────────────────────
+
+// RunSSHCATrust pushes the CA key and each instance's principal to running
+// instances that don't trust the current CA yet, e.g. ones launched before
+// it was configured or rotated, every interval until ctx is cancelled.
+func (h *Handler) RunSSHCATrust(ctx context.Context, interval time.Duration) {
+	if h.ca == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		insts, err := h.store.ListSSHCAStale(h.ca.Fingerprint())
+		if err != nil {
+			fmt.Printf("ssh-ca: list instances failed: %v\n", err)
+		}
+		for _, inst := range insts {
+			if err := h.trustCA(ctx, inst); err != nil {
+				fmt.Printf("ssh-ca: %s: %v\n", inst.Slug, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// trustCA makes an instance trust the CA for certificates naming its slug.
+func (h *Handler) trustCA(ctx context.Context, inst *instance.Instance) error {
+	if err := h.compute.TrustCA(ctx, target(inst), inst.Slug); err != nil {
+		return err
+	}
+	return h.store.UpdateSSHCA(inst.ID, h.ca.Fingerprint())
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/audit"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+// rotateSSHKey replaces an instance's SSH key in the background.
+func (h *Handler) rotateSSHKey(w http.ResponseWriter, r *http.Request) {
+	operator := strings.TrimSpace(r.Header.Get(operatorHeader))
+	if operator == "" {
+		http.Error(w, operatorHeader+" header is required", http.StatusBadRequest)
+		return
+	}
+
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	ok, err := h.beginKeyRotation(inst)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot rotate key of instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	go h.rotateKey(context.Background(), inst, operator)
+	jsonResponse(w, map[string]string{"status": "rotating"}, http.StatusAccepted)
+}
+
+// beginKeyRotation moves a running instance to rotating_key, which keeps
+// other rotations, upgrades, resizes and host replacements, all of which
+// use or store its key, from starting until rotateKey is done.
+func (h *Handler) beginKeyRotation(inst *instance.Instance) (bool, error) {
+	return h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive, instance.StatusDegraded}, instance.StatusRotatingKey)
+}
+
+// RecoverKeyRotations returns instances left rotating_key by a restart to
+// active. Both keys of an interrupted rotation stay valid until the next.
+func (h *Handler) RecoverKeyRotations() error {
+	stuck, err := h.store.ListByStatus(instance.StatusRotatingKey)
+	if err != nil {
+		return err
+	}
+	for _, inst := range stuck {
+		if _, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusRotatingKey}, instance.StatusActive); err != nil {
+			return err
+		}
+		fmt.Printf("ssh-key: %s was left rotating, back to active\n", inst.Slug)
+	}
+	return nil
+}
+
+// ── Rotation ──────────────────────────────────────────────────────────────────
+
+// RunKeyRotation rotates the key of every running instance older than
+// maxAge on each tick until ctx is cancelled.
+func (h *Handler) RunKeyRotation(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.rotateDueKeys(ctx, maxAge)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Handler) rotateDueKeys(ctx context.Context, maxAge time.Duration) {
+	insts, err := h.store.ListSSHKeyDue(time.Now().Add(-maxAge))
+	if err != nil {
+		fmt.Printf("ssh-key: list due instances failed: %v\n", err)
+		return
+	}
+	for _, inst := range insts {
+		if ctx.Err() != nil {
+			return
+		}
+		// Busy instances are picked up again on a later tick
+		if ok, err := h.beginKeyRotation(inst); err != nil || !ok {
+			continue
+		}
+		h.rotateKey(ctx, inst, "scheduler")
+	}
+}
+
+// rotateKey installs and verifies a new key, stores it, then revokes the
+// old one. The new key is stored before the old is revoked so a failure
+// part way never leaves the stored key unable to log in. The caller must
+// have begun the rotation; the instance returns to its previous status.
+func (h *Handler) rotateKey(ctx context.Context, inst *instance.Instance, operator string) {
+	defer h.store.UpdateStatus(inst.ID, inst.Status)
+
+	entry := &audit.Entry{InstanceID: inst.ID, Operator: operator, Action: "ssh-key-rotate"}
+	if err := h.audit.Start(entry); err != nil {
+		fmt.Printf("ssh-key: failed to write audit log for %s: %v\n", inst.Slug, err)
+		return
+	}
+	fmt.Printf("ssh-key: rotating key of %s (%s)\n", inst.Slug, operator)
+
+	err := h.replaceKey(ctx, inst)
+	if err != nil {
+		fmt.Printf("ssh-key: rotation failed for %s: %v\n", inst.Slug, err)
+	}
+	if err := h.audit.Finish(entry, "", err); err != nil {
+		fmt.Printf("ssh-key: failed to record audit entry %d: %v\n", entry.ID, err)
+	}
+}
+
+func (h *Handler) replaceKey(ctx context.Context, inst *instance.Instance) error {
+	host := target(inst).Host
+	newKey, err := h.compute.AddSSHKey(ctx, host, inst.SSHPrivateKey)
+	if err != nil {
+		return err
+	}
+	if err := h.store.UpdateSSHKey(inst.ID, newKey); err != nil {
+		return fmt.Errorf("store new key, both keys remain authorized: %w", err)
+	}
+	if err := h.compute.RemoveSSHKey(ctx, host, newKey, inst.SSHPrivateKey); err != nil {
+		return fmt.Errorf("new key stored, old key still authorized: %w", err)
+	}
+	return nil
+}
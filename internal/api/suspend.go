@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+func (h *Handler) suspendInstance(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive, instance.StatusDegraded}, instance.StatusSuspending)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot suspend instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	go func() {
+		if err := h.suspend(context.Background(), inst, instance.StatusSuspended); err != nil {
+			fmt.Printf("suspend: %s: %v\n", inst.Slug, err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) resumeInstance(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusSuspended}, instance.StatusResuming)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot resume instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	go func() {
+		if err := h.resume(context.Background(), inst); err != nil {
+			fmt.Printf("resume: %s: %v\n", inst.Slug, err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// suspend points the customer's hostname at the payment-required landing
+// page and stops (but keeps) their instance, leaving it in status `final`.
+// The caller has already moved the instance to StatusSuspending.
+func (h *Handler) suspend(ctx context.Context, inst *instance.Instance, final instance.Status) error {
+	// A failed suspend leaves the hub serving. Scheduled deletions still go
+	// ahead, so they stay pending rather than reverting to active.
+	revert := instance.StatusActive
+	if final == instance.StatusPendingDeletion {
+		revert = final
+	}
+
+	// 1. Swap DNS to the landing page
+	if err := h.dns.PointAtLanding(ctx, inst.Slug); err != nil {
+		h.store.UpdateStatus(inst.ID, revert)
+		return fmt.Errorf("dns: %w", err)
+	}
+
+	// 2. Stop EC2, restoring DNS if that fails
+	if err := h.computeFor(inst).Stop(ctx, inst.EC2InstanceID); err != nil {
+		if err := h.dns.UpdateRecord(ctx, inst.Slug, inst.EC2PublicIP, inst.EC2IPv6); err != nil {
+			fmt.Printf("suspend: dns restore failed for %s: %v\n", inst.Slug, err)
+		}
+		h.store.UpdateStatus(inst.ID, revert)
+		return fmt.Errorf("stop: %w", err)
+	}
+
+	h.store.UpdateStatus(inst.ID, final)
+	fmt.Printf("suspend: %s suspended\n", inst.Slug)
+	return nil
+}
+
+// resume starts a suspended instance and points DNS back at it. The caller
+// has already moved the instance to StatusResuming.
+func (h *Handler) resume(ctx context.Context, inst *instance.Instance) error {
+	// 1. Start EC2 — the public IP usually changes
+	ec2, err := h.computeFor(inst).Start(ctx, inst.EC2InstanceID)
+	if err != nil {
+		h.store.UpdateStatus(inst.ID, instance.StatusSuspended)
+		return fmt.Errorf("start: %w", err)
+	}
+	h.store.UpdateAddresses(inst.ID, ec2.PublicIP, ec2.PrivateIP, ec2.IPv6)
+	inst.EC2PublicIP = ec2.PublicIP
+
+	// 2. Restore DNS, stopping EC2 again if that fails so the record stays
+	// true; if it won't stop either, the hub is running and counts as active
+	if err := h.dns.UpdateRecord(ctx, inst.Slug, ec2.PublicIP, ec2.IPv6); err != nil {
+		status := instance.StatusSuspended
+		if serr := h.computeFor(inst).Stop(ctx, inst.EC2InstanceID); serr != nil {
+			fmt.Printf("resume: stop after dns failure failed for %s: %v\n", inst.Slug, serr)
+			status = instance.StatusActive
+		}
+		h.store.UpdateStatus(inst.ID, status)
+		return fmt.Errorf("dns: %w", err)
+	}
+
+	h.store.UpdateStatus(inst.ID, instance.StatusActive)
+	fmt.Printf("resume: %s is back at %s\n", inst.Slug, ec2.PublicIP)
+	return nil
+}
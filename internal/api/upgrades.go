@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/adgundersen/crimata-infra/internal/upgrade"
+	"github.com/go-chi/chi/v5"
+)
+
+type upgradeRequest struct {
+	Description    string   `json:"description"`
+	Script         string   `json:"script"`
+	BatchSize      int      `json:"batch_size"`       // default 10
+	CanarySize     int      `json:"canary_size"`      // default 1
+	MaxFailureRate *float64 `json:"max_failure_rate"` // default 0.1
+	Slugs          []string `json:"slugs"`            // limit to these instances; all active if empty
+}
+
+// upgradeOutputLimit caps the script output kept per instance.
+const upgradeOutputLimit = 16 << 10
+
+func (h *Handler) createUpgrade(w http.ResponseWriter, r *http.Request) {
+	var req upgradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Script) == "" {
+		http.Error(w, "script is required", http.StatusBadRequest)
+		return
+	}
+	if req.BatchSize <= 0 {
+		req.BatchSize = 10
+	}
+	if req.CanarySize <= 0 {
+		req.CanarySize = 1
+	}
+	maxFailureRate := 0.1
+	if req.MaxFailureRate != nil {
+		maxFailureRate = *req.MaxFailureRate
+	}
+	if maxFailureRate < 0 || maxFailureRate > 1 {
+		http.Error(w, "max_failure_rate must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	insts, err := h.store.ListByStatus(instance.StatusActive)
+	if err != nil {
+		http.Error(w, "failed to list instances", http.StatusInternalServerError)
+		return
+	}
+	if len(req.Slugs) > 0 {
+		wanted := make(map[string]bool, len(req.Slugs))
+		for _, s := range req.Slugs {
+			wanted[s] = true
+		}
+		var filtered []*instance.Instance
+		for _, inst := range insts {
+			if wanted[inst.Slug] {
+				filtered = append(filtered, inst)
+			}
+		}
+		insts = filtered
+	}
+	if len(insts) == 0 {
+		http.Error(w, "no active instances to upgrade", http.StatusConflict)
+		return
+	}
+
+	u := &upgrade.Upgrade{
+		Description:    req.Description,
+		Script:         req.Script,
+		BatchSize:      req.BatchSize,
+		CanarySize:     req.CanarySize,
+		MaxFailureRate: maxFailureRate,
+	}
+	for i, inst := range insts {
+		batch := 0
+		if i >= req.CanarySize {
+			batch = 1 + (i-req.CanarySize)/req.BatchSize
+		}
+		u.Results = append(u.Results, &upgrade.Result{InstanceID: inst.ID, Slug: inst.Slug, Batch: batch, Status: upgrade.ResultPending})
+	}
+	if err := h.upgrades.Create(u); err != nil {
+		http.Error(w, "failed to create upgrade", http.StatusInternalServerError)
+		return
+	}
+
+	// Encoded before the run starts filling in results
+	jsonResponse(w, u, http.StatusAccepted)
+	go h.runUpgrade(context.Background(), u)
+}
+
+func (h *Handler) listUpgrades(w http.ResponseWriter, r *http.Request) {
+	upgrades, err := h.upgrades.List()
+	if err != nil {
+		http.Error(w, "failed to list upgrades", http.StatusInternalServerError)
+		return
+	}
+	if upgrades == nil {
+		upgrades = []*upgrade.Upgrade{}
+	}
+	jsonResponse(w, upgrades, http.StatusOK)
+}
+
+func (h *Handler) getUpgrade(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	u, err := h.upgrades.Get(id)
+	if err != nil || u == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, u, http.StatusOK)
+}
+
+// ── Orchestration ─────────────────────────────────────────────────────────────
+
+// runUpgrade works through an upgrade's batches in order. Instances within a
+// batch are upgraded concurrently; the canary batch halts the upgrade on any
+// failure, later batches once the overall failure rate exceeds the limit.
+func (h *Handler) runUpgrade(ctx context.Context, u *upgrade.Upgrade) {
+	var batches [][]*upgrade.Result
+	for _, r := range u.Results {
+		for len(batches) <= r.Batch {
+			batches = append(batches, nil)
+		}
+		batches[r.Batch] = append(batches[r.Batch], r)
+	}
+
+	attempted, failed := 0, 0
+	for i, batch := range batches {
+		var wg sync.WaitGroup
+		for _, r := range batch {
+			wg.Add(1)
+			go func(r *upgrade.Result) {
+				defer wg.Done()
+				h.upgradeInstance(ctx, u, r)
+			}(r)
+		}
+		wg.Wait()
+
+		batchFailed := 0
+		for _, r := range batch {
+			switch r.Status {
+			case upgrade.ResultSucceeded:
+				attempted++
+			case upgrade.ResultFailed:
+				attempted++
+				failed++
+				batchFailed++
+			}
+		}
+		fmt.Printf("upgrade %d: batch %d done, %d/%d failed so far\n", u.ID, i, failed, attempted)
+
+		reason := ""
+		switch {
+		case i == 0 && batchFailed > 0:
+			reason = fmt.Sprintf("canary failed on %d of %d instances", batchFailed, len(batch))
+		case attempted > 0 && float64(failed)/float64(attempted) > u.MaxFailureRate:
+			reason = fmt.Sprintf("failure rate %d/%d exceeds %.0f%%", failed, attempted, u.MaxFailureRate*100)
+		}
+		if reason != "" {
+			fmt.Printf("upgrade %d: halted after batch %d: %s\n", u.ID, i, reason)
+			h.finishUpgrade(u, reason)
+			return
+		}
+	}
+
+	h.finishUpgrade(u, "")
+	fmt.Printf("upgrade %d: completed, %d/%d failed\n", u.ID, failed, attempted)
+}
+
+func (h *Handler) finishUpgrade(u *upgrade.Upgrade, reason string) {
+	if err := h.upgrades.Finish(u.ID, reason); err != nil {
+		fmt.Printf("upgrade %d: failed to record finish: %v\n", u.ID, err)
+	}
+}
+
+func (h *Handler) finishResult(u *upgrade.Upgrade, r *upgrade.Result) {
+	if err := h.upgrades.FinishResult(u.ID, r); err != nil {
+		fmt.Printf("upgrade %d: failed to record result for %s: %v\n", u.ID, r.Slug, err)
+	}
+}
+
+// RecoverUpgrades cleans up after upgrades whose orchestrator died with the
+// previous process: they are halted, since an instance may have been left
+// mid-script, and instances stuck upgrading are returned to active for the
+// health prober to judge. Call it once at startup, before serving.
+func (h *Handler) RecoverUpgrades() error {
+	running, err := h.upgrades.ListRunning()
+	if err != nil {
+		return err
+	}
+	for _, u := range running {
+		if err := h.upgrades.Interrupt(u.ID, "interrupted by restart"); err != nil {
+			return err
+		}
+		fmt.Printf("upgrade %d: interrupted by restart, halted\n", u.ID)
+	}
+
+	stuck, err := h.store.ListByStatus(instance.StatusUpgrading)
+	if err != nil {
+		return err
+	}
+	for _, inst := range stuck {
+		if _, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusUpgrading}, instance.StatusActive); err != nil {
+			return err
+		}
+		fmt.Printf("upgrade: %s was left upgrading, back to active\n", inst.Slug)
+	}
+	return nil
+}
+
+// upgradeInstance runs the upgrade script on one instance and checks it
+// still serves afterwards, recording the outcome in r.
+func (h *Handler) upgradeInstance(ctx context.Context, u *upgrade.Upgrade, r *upgrade.Result) {
+	inst, err := h.store.Get(r.InstanceID)
+	if err != nil || inst == nil {
+		r.Status, r.Error = upgrade.ResultSkipped, "instance not found"
+		h.finishResult(u, r)
+		return
+	}
+	ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive}, instance.StatusUpgrading)
+	if err != nil || !ok {
+		r.Status, r.Error = upgrade.ResultSkipped, "instance not active"
+		h.finishResult(u, r)
+		return
+	}
+	if err := h.upgrades.StartResult(u.ID, inst.ID); err != nil {
+		fmt.Printf("upgrade %d: failed to record start for %s: %v\n", u.ID, inst.Slug, err)
+	}
+
+	out, err := h.compute.Run(ctx, target(inst), u.Script)
+	r.Output = tail(out, upgradeOutputLimit)
+	if err == nil {
+		err = h.waitHealthy(ctx, inst, time.Minute)
+	}
+	final := instance.StatusActive
+	if err != nil {
+		r.Status, r.Error = upgrade.ResultFailed, err.Error()
+		fmt.Printf("upgrade %d: %s failed: %v\n", u.ID, inst.Slug, err)
+		final = instance.StatusDegraded
+	} else {
+		r.Status = upgrade.ResultSucceeded
+	}
+	if _, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusUpgrading}, final); err != nil {
+		fmt.Printf("upgrade %d: failed to update status of %s: %v\n", u.ID, inst.Slug, err)
+	}
+	h.finishResult(u, r)
+}
+
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+	"github.com/adgundersen/crimata-infra/internal/instance"
+	"github.com/go-chi/chi/v5"
+)
+
+type expandRequest struct {
+	SizeGB int32 `json:"size_gb"`
+}
+
+func (h *Handler) getVolume(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil || inst.EC2InstanceID == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	vol, err := h.computeFor(inst).RootVolume(r.Context(), inst.EC2InstanceID)
+	if err != nil {
+		http.Error(w, "failed to look up volume", http.StatusBadGateway)
+		return
+	}
+	jsonResponse(w, vol, http.StatusOK)
+}
+
+// expandVolume grows an instance's root volume and filesystem online. EBS
+// volumes can only grow; repeating the current size just retries growing
+// the filesystem.
+func (h *Handler) expandVolume(w http.ResponseWriter, r *http.Request) {
+	inst, err := h.store.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil || inst == nil || inst.EC2InstanceID == "" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var req expandRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	vol, err := h.computeFor(inst).RootVolume(r.Context(), inst.EC2InstanceID)
+	if err != nil {
+		http.Error(w, "failed to look up volume", http.StatusBadGateway)
+		return
+	}
+	if req.SizeGB < vol.SizeGB {
+		http.Error(w, fmt.Sprintf("size_gb must be at least the current %d GB", vol.SizeGB), http.StatusBadRequest)
+		return
+	}
+
+	ok, err := h.store.TransitionStatus(inst.ID, []instance.Status{instance.StatusActive, instance.StatusDegraded}, instance.StatusResizing)
+	if err != nil {
+		http.Error(w, "failed to update instance", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("cannot expand volume of instance in status %q", inst.Status), http.StatusConflict)
+		return
+	}
+
+	go func() {
+		err := h.expand(context.Background(), inst, vol, req.SizeGB)
+		if err != nil {
+			fmt.Printf("volume: %s: %v\n", inst.Slug, err)
+		}
+		// The instance keeps running throughout, so it returns to its status either
+		// way; a failed filesystem grow can be retried
+		h.store.UpdateStatus(inst.ID, inst.Status)
+	}()
+	jsonResponse(w, map[string]any{"volume_id": vol.VolumeID, "size_gb": req.SizeGB}, http.StatusAccepted)
+}
+
+func (h *Handler) expand(ctx context.Context, inst *instance.Instance, vol *compute.Volume, sizeGB int32) error {
+	if sizeGB > vol.SizeGB {
+		if err := h.computeFor(inst).ExpandVolume(ctx, vol.VolumeID, sizeGB); err != nil {
+			return err
+		}
+	}
+	out, err := h.compute.GrowFilesystem(ctx, target(inst))
+	if err != nil {
+		return fmt.Errorf("grow filesystem: %w", err)
+	}
+	fmt.Printf("volume: %s expanded to %d GB\n%s\n", inst.Slug, sizeGB, out)
+	return nil
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Entry records an operator's access to a customer instance: who did what,
+// and how it went.
+type Entry struct {
+	ID         int64      `json:"id"`
+	InstanceID int64      `json:"instance_id"`
+	Operator   string     `json:"operator"`
+	Action     string     `json:"action"` // e.g. "exec"
+	Detail     string     `json:"detail"` // the command run, certificate issued, ...
+	Status     Status     `json:"status"`
+	Output     string     `json:"output,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id           BIGSERIAL PRIMARY KEY,
+			instance_id  BIGINT NOT NULL,
+			operator     TEXT NOT NULL,
+			action       TEXT NOT NULL,
+			detail       TEXT NOT NULL DEFAULT '',
+			status       TEXT NOT NULL,
+			output       TEXT NOT NULL DEFAULT '',
+			error        TEXT NOT NULL DEFAULT '',
+			started_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at  TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS audit_log_instance_idx ON audit_log (instance_id, started_at DESC)
+	`)
+	return err
+}
+
+// Start records an entry as running before the action happens, so an
+// attempt is logged even if the process dies part way.
+func (s *Store) Start(e *Entry) error {
+	e.Status = StatusRunning
+	return s.db.QueryRow(`
+		INSERT INTO audit_log (instance_id, operator, action, detail, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, started_at`,
+		e.InstanceID, e.Operator, e.Action, e.Detail, e.Status,
+	).Scan(&e.ID, &e.StartedAt)
+}
+
+// Finish records the outcome: succeeded if err is nil, failed otherwise.
+func (s *Store) Finish(e *Entry, output string, err error) error {
+	e.Status, e.Output = StatusSucceeded, output
+	if err != nil {
+		e.Status, e.Error = StatusFailed, err.Error()
+	}
+	return s.db.QueryRow(`
+		UPDATE audit_log SET status = $1, output = $2, error = $3, finished_at = NOW()
+		WHERE id = $4
+		RETURNING finished_at`,
+		e.Status, e.Output, e.Error, e.ID,
+	).Scan(&e.FinishedAt)
+}
+
+// ListByInstance returns an instance's most recent entries, newest first.
+func (s *Store) ListByInstance(instanceID int64, limit int) ([]*Entry, error) {
+	rows, err := s.db.Query(`
+		SELECT id, instance_id, operator, action, detail, status, output, error, started_at, finished_at
+		FROM audit_log WHERE instance_id = $1
+		ORDER BY started_at DESC LIMIT $2`,
+		instanceID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		e := &Entry{}
+		if err := rows.Scan(&e.ID, &e.InstanceID, &e.Operator, &e.Action, &e.Detail, &e.Status, &e.Output, &e.Error, &e.StartedAt, &e.FinishedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
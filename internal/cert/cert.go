@@ -0,0 +1,214 @@
+package cert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+type Config struct {
+	DirectoryURL string // defaults to Let's Encrypt production
+	Email        string
+}
+
+// Certificate is a PEM-encoded certificate chain and its private key.
+type Certificate struct {
+	Domains   []string
+	CertPEM   string
+	KeyPEM    string
+	ExpiresAt time.Time
+}
+
+// Solver completes one type of ACME challenge for a domain.
+type Solver interface {
+	// Type is the ACME challenge type handled, e.g. "dns-01".
+	Type() string
+	Present(ctx context.Context, ac *acme.Client, domain, token string) error
+	CleanUp(ctx context.Context, ac *acme.Client, domain, token string) error
+}
+
+// SolverFor picks the solver for each domain on an order.
+type SolverFor func(domain string) Solver
+
+type Client struct {
+	store *Store
+	cfg   Config
+
+	mu   sync.Mutex
+	acme *acme.Client // lazily registered on first use
+}
+
+func NewClient(store *Store, cfg Config) *Client {
+	if cfg.DirectoryURL == "" {
+		cfg.DirectoryURL = acme.LetsEncryptURL
+	}
+	return &Client{store: store, cfg: cfg}
+}
+
+// Obtain orders a certificate covering domains, completing each domain's
+// authorization with the solver chosen for it.
+func (c *Client) Obtain(ctx context.Context, domains []string, solvers SolverFor) (*Certificate, error) {
+	ac, err := c.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := ac.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, fmt.Errorf("authorize order: %w", err)
+	}
+
+	for _, url := range order.AuthzURLs {
+		if err := c.authorize(ctx, ac, url, solvers); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = ac.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("wait order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("create csr: %w", err)
+	}
+
+	der, _, err := ac.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("create cert: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse cert: %w", err)
+	}
+
+	var chain []byte
+	for _, b := range der {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{
+		Domains:   domains,
+		CertPEM:   string(chain),
+		KeyPEM:    string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		ExpiresAt: leaf.NotAfter,
+	}, nil
+}
+
+func (c *Client) authorize(ctx context.Context, ac *acme.Client, url string, solvers SolverFor) error {
+	authz, err := ac.GetAuthorization(ctx, url)
+	if err != nil {
+		return fmt.Errorf("get authorization: %w", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	solver := solvers(authz.Identifier.Value)
+	var chal *acme.Challenge
+	for _, ch := range authz.Challenges {
+		if ch.Type == solver.Type() {
+			chal = ch
+			break
+		}
+	}
+	domain := authz.Identifier.Value
+	if chal == nil {
+		return fmt.Errorf("%s: no %s challenge offered", domain, solver.Type())
+	}
+
+	if err := solver.Present(ctx, ac, domain, chal.Token); err != nil {
+		return fmt.Errorf("%s: present challenge: %w", domain, err)
+	}
+	defer func() {
+		if err := solver.CleanUp(ctx, ac, domain, chal.Token); err != nil {
+			fmt.Printf("cert: cleanup failed for %s: %v\n", domain, err)
+		}
+	}()
+
+	if _, err := ac.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("%s: accept challenge: %w", domain, err)
+	}
+	if _, err := ac.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s: wait authorization: %w", domain, err)
+	}
+	return nil
+}
+
+// client returns the registered ACME client, creating and persisting an
+// account key on first use.
+func (c *Client) client(ctx context.Context) (*acme.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.acme != nil {
+		return c.acme, nil
+	}
+
+	key, err := c.accountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	ac := &acme.Client{Key: key, DirectoryURL: c.cfg.DirectoryURL}
+	acct := &acme.Account{}
+	if c.cfg.Email != "" {
+		acct.Contact = []string{"mailto:" + c.cfg.Email}
+	}
+	if _, err := ac.Register(ctx, acct, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("register acme account: %w", err)
+	}
+
+	c.acme = ac
+	return ac, nil
+}
+
+func (c *Client) accountKey() (crypto.Signer, error) {
+	keyPEM, err := c.store.AccountKey(c.cfg.DirectoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("load acme account key: %w", err)
+	}
+	if keyPEM != "" {
+		block, _ := pem.Decode([]byte(keyPEM))
+		if block == nil {
+			return nil, fmt.Errorf("invalid acme account key")
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err := c.store.SaveAccountKey(c.cfg.DirectoryURL, keyPEM); err != nil {
+		return nil, fmt.Errorf("save acme account key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,59 @@
+package cert
+
+import (
+	"context"
+
+	"golang.org/x/crypto/acme"
+)
+
+// TXTWriter publishes TXT records in a zone we control.
+type TXTWriter interface {
+	SetTXT(ctx context.Context, name, value string) error
+	DeleteTXT(ctx context.Context, name, value string) error
+}
+
+// DNSSolver answers dns-01 challenges by writing _acme-challenge TXT
+// records, so certificates can be issued before the hub's A record resolves.
+type DNSSolver struct {
+	DNS TXTWriter
+}
+
+func (s DNSSolver) Type() string { return "dns-01" }
+
+func (s DNSSolver) Present(ctx context.Context, ac *acme.Client, domain, token string) error {
+	value, err := ac.DNS01ChallengeRecord(token)
+	if err != nil {
+		return err
+	}
+	return s.DNS.SetTXT(ctx, "_acme-challenge."+domain, value)
+}
+
+func (s DNSSolver) CleanUp(ctx context.Context, ac *acme.Client, domain, token string) error {
+	value, err := ac.DNS01ChallengeRecord(token)
+	if err != nil {
+		return err
+	}
+	return s.DNS.DeleteTXT(ctx, "_acme-challenge."+domain, value)
+}
+
+// HTTPSolver answers http-01 challenges by publishing the key authorization
+// on the instance that serves the domain. It is used for customer-owned
+// domains, whose DNS we don't control.
+type HTTPSolver struct {
+	Publish   func(ctx context.Context, path, content string) error
+	Unpublish func(ctx context.Context, path string) error
+}
+
+func (s HTTPSolver) Type() string { return "http-01" }
+
+func (s HTTPSolver) Present(ctx context.Context, ac *acme.Client, domain, token string) error {
+	content, err := ac.HTTP01ChallengeResponse(token)
+	if err != nil {
+		return err
+	}
+	return s.Publish(ctx, ac.HTTP01ChallengePath(token), content)
+}
+
+func (s HTTPSolver) CleanUp(ctx context.Context, ac *acme.Client, domain, token string) error {
+	return s.Unpublish(ctx, ac.HTTP01ChallengePath(token))
+}
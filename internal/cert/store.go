@@ -0,0 +1,123 @@
+package cert
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Record is the certificate currently installed on an instance.
+type Record struct {
+	InstanceID int64     `json:"instance_id"`
+	Domains    []string  `json:"domains"`
+	CertPEM    string    `json:"-"`
+	KeyPEM     string    `json:"-"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	IssuedAt   time.Time `json:"issued_at"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS acme_accounts (
+			directory_url  TEXT PRIMARY KEY,
+			key_pem        TEXT NOT NULL,
+			created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS certificates (
+			instance_id  INTEGER PRIMARY KEY REFERENCES instances(id),
+			domains      TEXT[] NOT NULL,
+			cert_pem     TEXT NOT NULL,
+			key_pem      TEXT NOT NULL,
+			expires_at   TIMESTAMPTZ NOT NULL,
+			issued_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	return err
+}
+
+// AccountKey returns the ACME account key for a directory, or "" if none.
+func (s *Store) AccountKey(directoryURL string) (string, error) {
+	var key string
+	err := s.db.QueryRow(`SELECT key_pem FROM acme_accounts WHERE directory_url = $1`, directoryURL).Scan(&key)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return key, err
+}
+
+func (s *Store) SaveAccountKey(directoryURL, keyPEM string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO acme_accounts (directory_url, key_pem) VALUES ($1, $2)`,
+		directoryURL, keyPEM,
+	)
+	return err
+}
+
+// Save records the certificate installed on an instance, replacing any
+// previous one.
+func (s *Store) Save(instanceID int64, c *Certificate) error {
+	_, err := s.db.Exec(`
+		INSERT INTO certificates (instance_id, domains, cert_pem, key_pem, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (instance_id) DO UPDATE SET
+			domains    = EXCLUDED.domains,
+			cert_pem   = EXCLUDED.cert_pem,
+			key_pem    = EXCLUDED.key_pem,
+			expires_at = EXCLUDED.expires_at,
+			issued_at  = NOW()`,
+		instanceID, pq.Array(c.Domains), c.CertPEM, c.KeyPEM, c.ExpiresAt,
+	)
+	return err
+}
+
+func (s *Store) GetByInstance(instanceID int64) (*Record, error) {
+	rec := &Record{}
+	err := s.db.QueryRow(`
+		SELECT instance_id, domains, cert_pem, key_pem, expires_at, issued_at
+		FROM certificates WHERE instance_id = $1`,
+		instanceID,
+	).Scan(
+		&rec.InstanceID, pq.Array(&rec.Domains), &rec.CertPEM, &rec.KeyPEM,
+		&rec.ExpiresAt, &rec.IssuedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+// ListDue returns the IDs of running instances with no certificate or one
+// expiring before the given time.
+func (s *Store) ListDue(before time.Time) ([]int64, error) {
+	rows, err := s.db.Query(`
+		SELECT i.id
+		FROM instances i
+		LEFT JOIN certificates c ON c.instance_id = i.id
+		WHERE i.status IN ('active', 'degraded') AND (c.instance_id IS NULL OR c.expires_at < $1)
+		ORDER BY c.expires_at NULLS FIRST`,
+		before,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -1,68 +1,203 @@
 package compute
 
 import (
-	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
+	"crypto/sha256"
 	_ "embed"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/adgundersen/crimata-infra/internal/sshca"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"golang.org/x/crypto/ssh"
 )
 
-//go:embed provision.sh
-var provisionScript []byte
+//go:embed image.sh
+var imageScript []byte
+
+//go:embed metrics-agent.sh
+var metricsAgentScript []byte
+
+// MetricsAgentVersion identifies metrics-agent.sh, so hubs set up by an
+// older copy can be found and brought up to date.
+var MetricsAgentVersion = func() string {
+	sum := sha256.Sum256(metricsAgentScript)
+	return hex.EncodeToString(sum[:8])
+}()
 
 type Config struct {
 	AMI             string
 	InstanceType    string
 	SecurityGroupID string
 	SubnetID        string
+	ElasticIP       bool   // give each instance a stable Elastic IP
+	IPv6            bool   // request an IPv6 address; the subnet must have an IPv6 CIDR
+	IAMProfile      string // instance profile name, e.g. for the SSM agent
+	Executor        string // "ssh" (default) or "ssm" for running scripts on instances
+	SSHCAPublicKey  string // operator certificates signed by this key are trusted
+
+	// Regions are the other regions hubs can be migrated to.
+	Regions map[string]RegionConfig
+}
+
+// RegionConfig is what launching in a region needs beyond the client's own
+// settings; AMIs, security groups and subnets are all regional.
+type RegionConfig struct {
+	AMI             string
+	SecurityGroupID string
+	SubnetID        string
 }
 
 type Instance struct {
-	InstanceID    string
-	PublicIP      string
-	SSHPrivateKey string // PEM encoded
+	InstanceID      string
+	InstanceType    string
+	ImageID         string // the AMI it launched from; set by Launch only
+	PublicIP        string
+	PrivateIP       string
+	IPv6            string
+	EIPAllocationID string // set when PublicIP is an Elastic IP
+	Region          string // set by Launch only
+	SSHPrivateKey   string // PEM encoded
+}
+
+// Host returns the address to reach the instance on: its public IPv4, or its
+// IPv6 address if it has none.
+func (i *Instance) Host() string {
+	if i.PublicIP != "" {
+		return i.PublicIP
+	}
+	return i.IPv6
 }
 
 type Client struct {
-	ec2 *ec2.Client
-	cfg Config
+	ec2    *ec2.Client
+	exec   Executor
+	cfg    Config
+	region string
+
+	awsCfg   aws.Config
+	home     *Client // the client regional ones were derived from; nil for itself
+	mu       sync.Mutex
+	regional map[string]*Client
 }
 
 func NewClient(awsCfg aws.Config, cfg Config) *Client {
+	var exec Executor = SSHExecutor{}
+	if cfg.Executor == "ssm" {
+		exec = SSMExecutor{ssm: ssm.NewFromConfig(awsCfg)}
+	}
 	return &Client{
-		ec2: ec2.NewFromConfig(awsCfg),
-		cfg: cfg,
+		ec2:    ec2.NewFromConfig(awsCfg),
+		exec:   exec,
+		cfg:    cfg,
+		region: awsCfg.Region,
+		awsCfg: awsCfg,
 	}
 }
 
+// Region is the AWS region the client launches and manages instances in.
+func (c *Client) Region() string {
+	return c.region
+}
+
+// In returns a client for managing instances in region; empty means the
+// client's own. Instances can only be launched in the home region and the
+// configured Regions.
+func (c *Client) In(region string) *Client {
+	if c.home != nil {
+		return c.home.In(region)
+	}
+	if region == "" || region == c.region {
+		return c
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rc, ok := c.regional[region]; ok {
+		return rc
+	}
+	cfg := c.cfg
+	if r, ok := c.cfg.Regions[region]; ok {
+		cfg.AMI, cfg.SecurityGroupID, cfg.SubnetID = r.AMI, r.SecurityGroupID, r.SubnetID
+	} else {
+		cfg.AMI, cfg.SecurityGroupID, cfg.SubnetID = "", "", ""
+	}
+	awsCfg := c.awsCfg.Copy()
+	awsCfg.Region = region
+	rc := NewClient(awsCfg, cfg)
+	rc.home = c
+	if c.regional == nil {
+		c.regional = map[string]*Client{}
+	}
+	c.regional[region] = rc
+	return rc
+}
+
+// CanLaunchIn reports whether instances can be launched in region.
+func (c *Client) CanLaunchIn(region string) bool {
+	if c.home != nil {
+		return c.home.CanLaunchIn(region)
+	}
+	_, ok := c.cfg.Regions[region]
+	return region == c.region || ok
+}
+
+// LaunchSpec describes an instance to launch. Empty fields fall back to the
+// configured defaults.
+type LaunchSpec struct {
+	Slug         string
+	InstanceType string
+	ImageID      string // a golden AMI; defaults to the base AMI
+	SubnetID     string // e.g. another availability zone
+	Volume       VolumeSpec
+}
+
 // Launch starts a new EC2 instance, injecting a generated SSH public key.
-func (c *Client) Launch(ctx context.Context, slug string) (*Instance, error) {
+func (c *Client) Launch(ctx context.Context, spec LaunchSpec) (*Instance, error) {
+	slug, instanceType, imageID, subnetID := spec.Slug, spec.InstanceType, spec.ImageID, spec.SubnetID
+	if instanceType == "" {
+		instanceType = c.cfg.InstanceType
+	}
+	if imageID == "" {
+		imageID = c.cfg.AMI
+	}
+	if subnetID == "" {
+		subnetID = c.cfg.SubnetID
+	}
+	if imageID == "" || subnetID == "" {
+		return nil, fmt.Errorf("instances cannot be launched in %s: region not configured", c.region)
+	}
+
 	privateKey, publicKey, err := generateSSHKeyPair()
 	if err != nil {
 		return nil, fmt.Errorf("generate ssh key: %w", err)
 	}
 
 	userData := fmt.Sprintf("#!/bin/bash\nmkdir -p /root/.ssh\necho '%s' >> /root/.ssh/authorized_keys\nchmod 600 /root/.ssh/authorized_keys\n", publicKey)
+	if c.cfg.SSHCAPublicKey != "" {
+		userData += c.trustCAScript(slug)
+	}
 
-	out, err := c.ec2.RunInstances(ctx, &ec2.RunInstancesInput{
-		ImageId:          aws.String(c.cfg.AMI),
-		InstanceType:     ec2types.InstanceType(c.cfg.InstanceType),
+	input := &ec2.RunInstancesInput{
+		ImageId:          aws.String(imageID),
+		InstanceType:     ec2types.InstanceType(instanceType),
 		MinCount:         aws.Int32(1),
 		MaxCount:         aws.Int32(1),
 		SecurityGroupIds: []string{c.cfg.SecurityGroupID},
-		SubnetId:         aws.String(c.cfg.SubnetID),
+		SubnetId:         aws.String(subnetID),
 		UserData:         aws.String(base64.StdEncoding.EncodeToString([]byte(userData))),
 		TagSpecifications: []ec2types.TagSpecification{
 			{
@@ -74,7 +209,32 @@ func (c *Client) Launch(ctx context.Context, slug string) (*Instance, error) {
 				},
 			},
 		},
-	})
+	}
+	if !spec.Volume.empty() {
+		mapping, err := c.rootDeviceMapping(ctx, imageID, spec.Volume)
+		if err != nil {
+			return nil, err
+		}
+		input.BlockDeviceMappings = []ec2types.BlockDeviceMapping{mapping}
+	}
+	if c.cfg.IAMProfile != "" {
+		input.IamInstanceProfile = &ec2types.IamInstanceProfileSpecification{Name: aws.String(c.cfg.IAMProfile)}
+	}
+	if c.cfg.IPv6 {
+		// An IPv6 address can only be requested on an explicit network
+		// interface, which then carries the subnet and security group.
+		input.NetworkInterfaces = []ec2types.InstanceNetworkInterfaceSpecification{
+			{
+				DeviceIndex:      aws.Int32(0),
+				SubnetId:         input.SubnetId,
+				Groups:           input.SecurityGroupIds,
+				Ipv6AddressCount: aws.Int32(1),
+			},
+		}
+		input.SubnetId, input.SecurityGroupIds = nil, nil
+	}
+
+	out, err := c.ec2.RunInstances(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("run instances: %w", err)
 	}
@@ -82,86 +242,474 @@ func (c *Client) Launch(ctx context.Context, slug string) (*Instance, error) {
 	instance := out.Instances[0]
 	return &Instance{
 		InstanceID:    aws.ToString(instance.InstanceId),
+		InstanceType:  instanceType,
+		ImageID:       imageID,
 		PublicIP:      aws.ToString(instance.PublicIpAddress),
+		Region:        c.region,
 		SSHPrivateKey: privateKey,
 	}, nil
 }
 
-// WaitUntilReady waits for the instance to be running and SSH-reachable.
-func (c *Client) WaitUntilReady(ctx context.Context, instanceID, publicIP string) error {
+// trustCAScript makes sshd accept user certificates signed by the CA key,
+// as the login user only and only if they name this instance's principal.
+const trustCAScript = `echo '%[1]s' > /etc/ssh/crimata_ca.pub
+mkdir -p /etc/ssh/crimata_principals
+echo '%[3]s' > /etc/ssh/crimata_principals/%[2]s
+cat > /etc/ssh/sshd_config.d/crimata-ca.conf << 'EOF'
+TrustedUserCAKeys /etc/ssh/crimata_ca.pub
+AuthorizedPrincipalsFile /etc/ssh/crimata_principals/%%u
+EOF
+systemctl reload ssh
+`
+
+func (c *Client) trustCAScript(slug string) string {
+	return fmt.Sprintf(trustCAScript, c.cfg.SSHCAPublicKey, sshca.LoginUser, sshca.Principal(slug))
+}
+
+// TrustCA installs or refreshes the CA key and the instance's principal on
+// a running instance, e.g. one launched before the CA was configured or
+// claimed from the warm pool under a placeholder slug.
+func (c *Client) TrustCA(ctx context.Context, t Target, slug string) error {
+	if c.cfg.SSHCAPublicKey == "" {
+		return errors.New("no ssh ca configured")
+	}
+	_, err := c.Run(ctx, t, "set -e\n"+c.trustCAScript(slug))
+	return err
+}
+
+// ElasticIPEnabled reports whether instances should get an Elastic IP.
+func (c *Client) ElasticIPEnabled() bool {
+	return c.cfg.ElasticIP
+}
+
+// AssignElasticIP allocates an Elastic IP tagged for slug and associates it
+// with the instance once it is running, so the address survives stop/start.
+// The allocation is released again if association fails.
+func (c *Client) AssignElasticIP(ctx context.Context, slug, instanceID string) (allocationID, publicIP string, err error) {
+	waiter := ec2.NewInstanceRunningWaiter(c.ec2)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, 5*time.Minute); err != nil {
+		return "", "", err
+	}
+
+	out, err := c.ec2.AllocateAddress(ctx, &ec2.AllocateAddressInput{
+		Domain: ec2types.DomainTypeVpc,
+		TagSpecifications: []ec2types.TagSpecification{
+			{
+				ResourceType: ec2types.ResourceTypeElasticIp,
+				Tags: []ec2types.Tag{
+					{Key: aws.String("Name"), Value: aws.String("crimata-" + slug)},
+					{Key: aws.String("crimata:slug"), Value: aws.String(slug)},
+					{Key: aws.String("crimata:managed"), Value: aws.String("true")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("allocate address: %w", err)
+	}
+	allocationID = aws.ToString(out.AllocationId)
+
+	if _, err := c.ec2.AssociateAddress(ctx, &ec2.AssociateAddressInput{
+		AllocationId: aws.String(allocationID),
+		InstanceId:   aws.String(instanceID),
+	}); err != nil {
+		c.ReleaseElasticIP(ctx, allocationID)
+		return "", "", fmt.Errorf("associate address: %w", err)
+	}
+	return allocationID, aws.ToString(out.PublicIp), nil
+}
+
+// ReleaseElasticIP disassociates an Elastic IP, if still attached, and
+// returns it to AWS.
+func (c *Client) ReleaseElasticIP(ctx context.Context, allocationID string) error {
+	out, err := c.ec2.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{
+		AllocationIds: []string{allocationID},
+	})
+	if err != nil {
+		return fmt.Errorf("describe address: %w", err)
+	}
+	for _, addr := range out.Addresses {
+		if addr.AssociationId == nil {
+			continue
+		}
+		if _, err := c.ec2.DisassociateAddress(ctx, &ec2.DisassociateAddressInput{
+			AssociationId: addr.AssociationId,
+		}); err != nil {
+			return fmt.Errorf("disassociate address: %w", err)
+		}
+	}
+
+	if _, err := c.ec2.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{
+		AllocationId: aws.String(allocationID),
+	}); err != nil {
+		return fmt.Errorf("release address: %w", err)
+	}
+	return nil
+}
+
+// WaitUntilReady waits for the instance to be running and SSH-reachable and
+// returns it with the addresses AWS assigned. RunInstances responds before
+// any public address exists, so callers must use these instead.
+func (c *Client) WaitUntilReady(ctx context.Context, instanceID string) (*Instance, error) {
 	// Wait for EC2 running state
 	waiter := ec2.NewInstanceRunningWaiter(c.ec2)
 	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
 		InstanceIds: []string{instanceID},
 	}, 5*time.Minute); err != nil {
-		return err
+		return nil, err
+	}
+
+	inst, err := c.describe(ctx, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	if inst.Host() == "" {
+		return nil, fmt.Errorf("instance %s is running but has no public IPv4 or IPv6 address", instanceID)
 	}
 
 	// Poll until SSH port is open
+	addr := net.JoinHostPort(inst.Host(), "22")
 	deadline := time.Now().Add(5 * time.Minute)
 	for time.Now().Before(deadline) {
-		conn, err := net.DialTimeout("tcp", publicIP+":22", 5*time.Second)
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
 		if err == nil {
 			conn.Close()
-			return nil
+			return inst, nil
 		}
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		case <-time.After(10 * time.Second):
 		}
 	}
-	return fmt.Errorf("timed out waiting for SSH on %s", publicIP)
+	return nil, fmt.Errorf("timed out waiting for SSH on %s", addr)
+}
+
+// ProvisionSpec is a per-customer provisioning run.
+type ProvisionSpec struct {
+	Script string
+	Params map[string]string // exported to the script as environment variables
+	Args   []string          // positional arguments
+	Baked  bool              // the instance already has image.sh's base install
 }
 
-// Provision runs provision.sh on the instance over SSH.
-func (c *Client) Provision(ctx context.Context, publicIP, privateKeyPEM, slug, password, dbPassword string) error {
-	signer, err := parsePrivateKey(privateKeyPEM)
+// Provision runs a provisioning script on the instance over SSH. Instances
+// launched from the base AMI rather than a golden image run the image
+// scripts first to install everything the image would have.
+func (c *Client) Provision(ctx context.Context, host, privateKeyPEM string, spec ProvisionSpec) error {
+	// Retry SSH connection — user data script may still be running
+	client, err := dialSSH(ctx, host, privateKeyPEM, 2*time.Minute)
 	if err != nil {
-		return fmt.Errorf("parse private key: %w", err)
+		return err
 	}
+	defer client.Close()
 
-	config := &ssh.ClientConfig{
-		User:            "ubuntu",
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
+	if !spec.Baked {
+		if err := runImageScripts(client); err != nil {
+			return err
+		}
 	}
 
-	// Retry SSH connection — user data script may still be running
-	var client *ssh.Client
-	deadline := time.Now().Add(2 * time.Minute)
-	for time.Now().Before(deadline) {
-		client, err = ssh.Dial("tcp", publicIP+":22", config)
-		if err == nil {
-			break
-		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(10 * time.Second):
-		}
+	// sudo resets the environment, so params are exported inside the script
+	keys := make([]string, 0, len(spec.Params))
+	for k := range spec.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var script strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&script, "export %s=%s\n", k, shellQuote(spec.Params[k]))
+	}
+	script.WriteString(spec.Script)
+
+	if err := runScript(client, []byte(script.String()), spec.Args...); err != nil {
+		return fmt.Errorf("provision script: %w", err)
 	}
-	if client == nil {
-		return fmt.Errorf("could not connect via SSH: %w", err)
+	return nil
+}
+
+// Prepare runs the image scripts on an instance launched from the base AMI, leaving
+// it in the same state as one launched from a golden image.
+func (c *Client) Prepare(ctx context.Context, host, privateKeyPEM string) error {
+	client, err := dialSSH(ctx, host, privateKeyPEM, 2*time.Minute)
+	if err != nil {
+		return err
 	}
 	defer client.Close()
 
-	// Upload and run provision.sh
-	session, err := client.NewSession()
+	return runImageScripts(client)
+}
+
+// runImageScripts runs image.sh and then metrics-agent.sh.
+func runImageScripts(client *ssh.Client) error {
+	if err := runScript(client, imageScript); err != nil {
+		return fmt.Errorf("image script: %w", err)
+	}
+	if err := runScript(client, metricsAgentScript); err != nil {
+		return fmt.Errorf("metrics agent script: %w", err)
+	}
+	return nil
+}
+
+// InstallMetricsAgent runs metrics-agent.sh on a running instance, e.g. one
+// launched from an image baked before the agent was added or changed.
+func (c *Client) InstallMetricsAgent(ctx context.Context, t Target) error {
+	_, err := c.Run(ctx, t, string(metricsAgentScript))
+	return err
+}
+
+// Rename retags an instance for a new slug, e.g. when a warm pool instance
+// is handed to a customer.
+func (c *Client) Rename(ctx context.Context, instanceID, slug string) error {
+	_, err := c.ec2.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{instanceID},
+		Tags: []ec2types.Tag{
+			{Key: aws.String("Name"), Value: aws.String("crimata-" + slug)},
+			{Key: aws.String("crimata:slug"), Value: aws.String(slug)},
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("new ssh session: %w", err)
+		return fmt.Errorf("tag instance %s: %w", instanceID, err)
 	}
-	defer session.Close()
+	return nil
+}
 
-	session.Stdin = bytes.NewReader(provisionScript)
-	cmd := fmt.Sprintf("bash -s -- %s %s %s", slug, password, dbPassword)
-	if err := session.Run(cmd); err != nil {
-		return fmt.Errorf("provision script: %w", err)
+// sealScript strips the builder's identity before it is snapshotted, so
+// instances launched from the image only trust their own generated key and
+// re-run cloud-init as a fresh boot.
+const sealScript = `set -euo pipefail
+truncate -s 0 /root/.ssh/authorized_keys
+cloud-init clean --logs
+`
+
+// Bake turns a freshly launched base-AMI instance into a golden image
+// candidate: it runs the image scripts and then seals the instance for CreateImage.
+func (c *Client) Bake(ctx context.Context, host, privateKeyPEM string) error {
+	client, err := dialSSH(ctx, host, privateKeyPEM, 2*time.Minute)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := runImageScripts(client); err != nil {
+		return err
+	}
+	if err := runScript(client, []byte(sealScript)); err != nil {
+		return fmt.Errorf("seal: %w", err)
+	}
+	return nil
+}
+
+// CreateImage snapshots an instance into an AMI named after version and
+// waits for it to become available.
+func (c *Client) CreateImage(ctx context.Context, instanceID, version string) (string, error) {
+	tags := []ec2types.Tag{
+		{Key: aws.String("Name"), Value: aws.String("crimata-os-" + version)},
+		{Key: aws.String("crimata:image-version"), Value: aws.String(version)},
+		{Key: aws.String("crimata:managed"), Value: aws.String("true")},
+	}
+	out, err := c.ec2.CreateImage(ctx, &ec2.CreateImageInput{
+		InstanceId:  aws.String(instanceID),
+		Name:        aws.String("crimata-os-" + version),
+		Description: aws.String("Crimata OS golden image " + version),
+		TagSpecifications: []ec2types.TagSpecification{
+			{ResourceType: ec2types.ResourceTypeImage, Tags: tags},
+			{ResourceType: ec2types.ResourceTypeSnapshot, Tags: tags},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("create image: %w", err)
+	}
+	imageID := aws.ToString(out.ImageId)
+
+	waiter := ec2.NewImageAvailableWaiter(c.ec2)
+	if err := waiter.Wait(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	}, 45*time.Minute); err != nil {
+		return imageID, fmt.Errorf("wait for image %s: %w", imageID, err)
+	}
+	return imageID, nil
+}
+
+// BaseAMI returns the configured stock AMI golden images are built from.
+func (c *Client) BaseAMI() string {
+	return c.cfg.AMI
+}
+
+// InstallCertificate writes a TLS certificate and key onto the instance and
+// enables nginx's HTTPS listener, which provision.sh includes from
+// /etc/nginx/crimata/.
+func (c *Client) InstallCertificate(ctx context.Context, t Target, certPEM, keyPEM string) error {
+	script := fmt.Sprintf(`set -euo pipefail
+mkdir -p /etc/crimata/tls /etc/nginx/crimata
+cat > /etc/crimata/tls/fullchain.pem << 'CRIMATA_EOF'
+%s
+CRIMATA_EOF
+(umask 077; cat > /etc/crimata/tls/privkey.pem << 'CRIMATA_EOF'
+%s
+CRIMATA_EOF
+)
+cat > /etc/nginx/crimata/tls.conf << 'CRIMATA_EOF'
+listen 443 ssl;
+listen [::]:443 ssl;
+ssl_certificate     /etc/crimata/tls/fullchain.pem;
+ssl_certificate_key /etc/crimata/tls/privkey.pem;
+CRIMATA_EOF
+nginx -t
+systemctl reload nginx
+`, strings.TrimSpace(certPEM), strings.TrimSpace(keyPEM))
+
+	if _, err := c.Run(ctx, t, script); err != nil {
+		return fmt.Errorf("install certificate: %w", err)
 	}
 	return nil
 }
 
+// SetServerNames adds extra hostnames, such as verified custom domains, to
+// the instance's nginx server block.
+func (c *Client) SetServerNames(ctx context.Context, t Target, names []string) error {
+	conf := ""
+	if len(names) > 0 {
+		conf = "server_name " + strings.Join(names, " ") + ";"
+	}
+	script := fmt.Sprintf(`set -euo pipefail
+mkdir -p /etc/nginx/crimata
+cat > /etc/nginx/crimata/domains.conf << 'CRIMATA_EOF'
+%s
+CRIMATA_EOF
+nginx -t
+systemctl reload nginx
+`, conf)
+
+	if _, err := c.Run(ctx, t, script); err != nil {
+		return fmt.Errorf("set server names: %w", err)
+	}
+	return nil
+}
+
+// PublishChallenge serves an ACME http-01 response at path (starting with
+// /.well-known/acme-challenge/) from the instance's nginx.
+func (c *Client) PublishChallenge(ctx context.Context, t Target, path, content string) error {
+	script := fmt.Sprintf(`set -euo pipefail
+mkdir -p /var/www/acme/.well-known/acme-challenge
+printf '%%s' %s > /var/www/acme%s
+`, shellQuote(content), path)
+
+	if _, err := c.Run(ctx, t, script); err != nil {
+		return fmt.Errorf("publish challenge: %w", err)
+	}
+	return nil
+}
+
+// RemoveChallenge deletes a response written by PublishChallenge.
+func (c *Client) RemoveChallenge(ctx context.Context, t Target, path string) error {
+	_, err := c.Run(ctx, t, fmt.Sprintf("rm -f /var/www/acme%s\n", path))
+	return err
+}
+
+// Stop halts a customer's EC2 instance without terminating it, keeping its
+// root volume, and waits until it has stopped.
+func (c *Client) Stop(ctx context.Context, instanceID string) error {
+	if _, err := c.ec2.StopInstances(ctx, &ec2.StopInstancesInput{
+		InstanceIds: []string{instanceID},
+	}); err != nil {
+		return fmt.Errorf("stop instances: %w", err)
+	}
+
+	waiter := ec2.NewInstanceStoppedWaiter(c.ec2)
+	return waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, 10*time.Minute)
+}
+
+// Start boots a stopped instance and waits until it is running. The public
+// IP usually changes across a stop/start, so the refreshed address is
+// returned.
+func (c *Client) Start(ctx context.Context, instanceID string) (*Instance, error) {
+	if _, err := c.ec2.StartInstances(ctx, &ec2.StartInstancesInput{
+		InstanceIds: []string{instanceID},
+	}); err != nil {
+		return nil, fmt.Errorf("start instances: %w", err)
+	}
+
+	waiter := ec2.NewInstanceRunningWaiter(c.ec2)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, 10*time.Minute); err != nil {
+		return nil, err
+	}
+	return c.describe(ctx, instanceID)
+}
+
+// ErrUnchanged reports that an operation failed before touching the instance.
+var ErrUnchanged = errors.New("instance unchanged")
+
+// Resize moves an instance to a new instance type: stop, modify, start. If
+// the new type fails to apply or boot, the old type is restored and the
+// instance restarted. Whenever the instance ends up running, its refreshed
+// details are returned, alongside any error.
+func (c *Client) Resize(ctx context.Context, instanceID, instanceType string) (*Instance, error) {
+	current, err := c.describe(ctx, instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnchanged, err)
+	}
+	if current.InstanceType == instanceType {
+		return current, nil
+	}
+
+	if err := c.Stop(ctx, instanceID); err != nil {
+		// The stop may have got partway; make sure it is running again
+		inst, startErr := c.Start(ctx, instanceID)
+		if startErr != nil {
+			return nil, fmt.Errorf("stop: %w; restart failed: %v", err, startErr)
+		}
+		return inst, fmt.Errorf("resize to %s aborted: stop: %w", instanceType, err)
+	}
+
+	resizeErr := c.setInstanceType(ctx, instanceID, instanceType)
+	if resizeErr == nil {
+		inst, err := c.Start(ctx, instanceID)
+		if err == nil {
+			return inst, nil
+		}
+		resizeErr = fmt.Errorf("start as %s: %w", instanceType, err)
+		// Start can fail after the instance began booting
+		c.Stop(ctx, instanceID)
+	}
+
+	// Roll back to the original type
+	if err := c.setInstanceType(ctx, instanceID, current.InstanceType); err != nil {
+		return nil, fmt.Errorf("%w; rollback to %s failed: %v", resizeErr, current.InstanceType, err)
+	}
+	inst, err := c.Start(ctx, instanceID)
+	if err != nil {
+		return nil, fmt.Errorf("%w; restart as %s failed: %v", resizeErr, current.InstanceType, err)
+	}
+	return inst, fmt.Errorf("resize to %s rolled back: %w", instanceType, resizeErr)
+}
+
+func (c *Client) setInstanceType(ctx context.Context, instanceID, instanceType string) error {
+	_, err := c.ec2.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId:   aws.String(instanceID),
+		InstanceType: &ec2types.AttributeValue{Value: aws.String(instanceType)},
+	})
+	if err != nil {
+		return fmt.Errorf("modify instance type: %w", err)
+	}
+	return nil
+}
+
+// Reboot restarts an instance in place. EC2 returns immediately; callers
+// should wait for the hub to answer again.
+func (c *Client) Reboot(ctx context.Context, instanceID string) error {
+	_, err := c.ec2.RebootInstances(ctx, &ec2.RebootInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	return err
+}
+
 // Terminate shuts down a customer's EC2 instance.
 func (c *Client) Terminate(ctx context.Context, instanceID string) error {
 	_, err := c.ec2.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
@@ -170,23 +718,58 @@ func (c *Client) Terminate(ctx context.Context, instanceID string) error {
 	return err
 }
 
+// Describe returns an instance's current type and addresses.
+func (c *Client) Describe(ctx context.Context, instanceID string) (*Instance, error) {
+	return c.describe(ctx, instanceID)
+}
+
+func (c *Client) describe(ctx context.Context, instanceID string) (*Instance, error) {
+	out, err := c.ec2.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe instances: %w", err)
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+
+	instance := out.Reservations[0].Instances[0]
+	inst := &Instance{
+		InstanceID:   aws.ToString(instance.InstanceId),
+		InstanceType: string(instance.InstanceType),
+		PublicIP:     aws.ToString(instance.PublicIpAddress),
+		PrivateIP:    aws.ToString(instance.PrivateIpAddress),
+		IPv6:         aws.ToString(instance.Ipv6Address),
+	}
+	if inst.IPv6 == "" {
+		for _, ni := range instance.NetworkInterfaces {
+			if len(ni.Ipv6Addresses) > 0 {
+				inst.IPv6 = aws.ToString(ni.Ipv6Addresses[0].Ipv6Address)
+				break
+			}
+		}
+	}
+	return inst, nil
+}
+
 func generateSSHKeyPair() (privateKeyPEM string, authorizedKey string, err error) {
-	key, err := rsa.GenerateKey(rand.Reader, 4096)
+	pubKey, key, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return "", "", err
 	}
 
-	privPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	})
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return "", "", err
+	}
 
-	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	pub, err := ssh.NewPublicKey(pubKey)
 	if err != nil {
 		return "", "", err
 	}
 
-	return string(privPEM), string(ssh.MarshalAuthorizedKey(pub)), nil
+	return string(pem.EncodeToMemory(block)), string(ssh.MarshalAuthorizedKey(pub)), nil
 }
 
 func parsePrivateKey(pemBytes string) (ssh.Signer, error) {
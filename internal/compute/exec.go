@@ -0,0 +1,196 @@
+package compute
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"golang.org/x/crypto/ssh"
+)
+
+// Target identifies the instance a script runs on.
+type Target struct {
+	InstanceID    string
+	Region        string // empty for the client's own; SSM is regional
+	Host          string
+	SSHPrivateKey string // PEM encoded
+}
+
+// Executor runs a shell script as root on a customer instance, streaming
+// its combined output to out.
+type Executor interface {
+	Run(ctx context.Context, t Target, script string, out io.Writer) error
+}
+
+// SSHExecutor runs scripts over SSH with the instance's own key.
+type SSHExecutor struct{}
+
+func (SSHExecutor) Run(ctx context.Context, t Target, script string, out io.Writer) error {
+	client, err := dialSSH(ctx, t.Host, t.SSHPrivateKey, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("new ssh session: %w", err)
+	}
+	defer session.Close()
+
+	// The session copies stdout and stderr concurrently
+	w := &syncWriter{w: out}
+	session.Stdin = strings.NewReader(script)
+	session.Stdout = w
+	session.Stderr = w
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run("sudo bash -s") }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		session.Signal(ssh.SIGKILL)
+		return ctx.Err()
+	}
+}
+
+// SSMExecutor runs scripts through SSM Run Command. It needs no inbound SSH
+// but the instance must run the SSM agent under an instance profile that
+// allows it.
+type SSMExecutor struct {
+	ssm *ssm.Client
+}
+
+func (e SSMExecutor) Run(ctx context.Context, t Target, script string, out io.Writer) error {
+	sent, err := e.ssm.SendCommand(ctx, &ssm.SendCommandInput{
+		InstanceIds:  []string{t.InstanceID},
+		DocumentName: aws.String("AWS-RunShellScript"),
+		Parameters:   map[string][]string{"commands": {script}},
+	})
+	if err != nil {
+		return fmt.Errorf("send command: %w", err)
+	}
+
+	invocation := &ssm.GetCommandInvocationInput{
+		CommandId:  sent.Command.CommandId,
+		InstanceId: aws.String(t.InstanceID),
+	}
+	waitErr := ssm.NewCommandExecutedWaiter(e.ssm).Wait(ctx, invocation, 30*time.Minute)
+
+	// The waiter fails on a non-zero exit too; the output is still wanted
+	result, err := e.ssm.GetCommandInvocation(ctx, invocation)
+	if err != nil {
+		if waitErr != nil {
+			return waitErr
+		}
+		return fmt.Errorf("get command output: %w", err)
+	}
+	io.WriteString(out, aws.ToString(result.StandardOutputContent))
+	io.WriteString(out, aws.ToString(result.StandardErrorContent))
+	if waitErr != nil {
+		return fmt.Errorf("command %s: %s", result.Status, aws.ToString(result.StatusDetails))
+	}
+	return nil
+}
+
+// Run executes script on the instance, returning its output. A non-zero exit
+// is reported with the tail of the output.
+func (c *Client) Run(ctx context.Context, t Target, script string) (string, error) {
+	var out bytes.Buffer
+	if err := c.In(t.Region).exec.Run(ctx, t, script, &out); err != nil {
+		return out.String(), fmt.Errorf("%w: %s", err, tail(out.String(), 512))
+	}
+	return out.String(), nil
+}
+
+// Stream executes script on the instance, writing its output to out as the
+// executor receives it: as it is produced over SSH, but only once the
+// command has finished through SSM.
+func (c *Client) Stream(ctx context.Context, t Target, script string, out io.Writer) error {
+	return c.In(t.Region).exec.Run(ctx, t, script, out)
+}
+
+// runScript pipes script into bash as root over an established connection,
+// passing args as positional parameters.
+func runScript(client *ssh.Client, script []byte, args ...string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("new ssh session: %w", err)
+	}
+	defer session.Close()
+
+	cmd := "sudo bash -s"
+	if len(args) > 0 {
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			quoted[i] = shellQuote(a)
+		}
+		cmd += " -- " + strings.Join(quoted, " ")
+	}
+	session.Stdin = bytes.NewReader(script)
+	return session.Run(cmd)
+}
+
+// dialSSH connects to host as ubuntu, retrying until timeout while sshd and
+// the user data script come up.
+func dialSSH(ctx context.Context, host, privateKeyPEM string, timeout time.Duration) (*ssh.Client, error) {
+	signer, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+
+	config := &ssh.ClientConfig{
+		User:            "ubuntu",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		client, err := ssh.Dial("tcp", net.JoinHostPort(host, "22"), config)
+		if err == nil {
+			return client, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("could not connect via SSH: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func tail(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	return "…" + s[len(s)-n:]
+}
+
+// shellQuote quotes s for safe use as a single bash word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
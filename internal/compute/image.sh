@@ -0,0 +1,62 @@
+#!/bin/bash
+# image.sh — Crimata OS base image: everything that isn't customer-specific
+# Run once per golden AMI build, or before provision.sh when no image exists.
+# metrics-agent.sh runs right after it.
+
+set -euo pipefail
+
+OS_REPO="https://github.com/adgundersen/os"
+
+log() { echo "[crimata] $1"; }
+
+# ── 1. System dependencies ──────────────────────────────────────────────────
+log "Installing system dependencies..."
+apt-get update -qq
+apt-get install -y -qq \
+    nginx \
+    postgresql postgresql-contrib \
+    gcc make \
+    libmicrohttpd-dev \
+    libpam-dev \
+    libsystemd-dev \
+    nodejs npm \
+    git
+
+# ── 2. Fetch OS source ──────────────────────────────────────────────────────
+log "Fetching OS..."
+git clone --depth 1 "$OS_REPO" /tmp/crimata-os
+
+# ── 3. Build and install core binaries ─────────────────────────────────────
+log "Building crimata-auth..."
+mkdir -p /opt/crimata/bin
+make -C /tmp/crimata-os/auth OUT=/opt/crimata/bin/crimata-auth
+
+log "Building crimata-dock..."
+make -C /tmp/crimata-os/dock OUT=/opt/crimata/bin/crimata-dock
+
+# ── 4. Install web desktop UI ───────────────────────────────────────────────
+log "Installing UI..."
+mkdir -p /opt/crimata/ui
+cp -r /tmp/crimata-os/ui/* /opt/crimata/ui/
+
+# ── 5. Install apps ─────────────────────────────────────────────────────────
+log "Installing crimata-contacts..."
+mkdir -p /usr/lib/crimata-contacts
+cp -r /tmp/crimata-os/apps/contacts/* /usr/lib/crimata-contacts/
+cd /usr/lib/crimata-contacts
+npm install --silent
+npm run build
+
+# ── 6. Install crimata-agent ────────────────────────────────────────────────
+log "Installing crimata-agent..."
+mkdir -p /usr/lib/crimata-agent
+cp -r /tmp/crimata-os/agent/* /usr/lib/crimata-agent/
+cd /usr/lib/crimata-agent
+npm install --silent
+npm run build
+
+# ── 7. Cleanup ───────────────────────────────────────────────────────────────
+rm -rf /tmp/crimata-os
+apt-get clean
+
+log "Base image ready."
@@ -0,0 +1,31 @@
+#!/bin/bash
+# metrics-agent.sh — CloudWatch agent reporting memory and root disk usage
+# for the metrics collector. Runs after image.sh on new images, and on
+# running hubs whenever it changes. Needs an instance profile allowed to put
+# metrics (EC2_IAM_PROFILE).
+
+set -euo pipefail
+
+log() { echo "[crimata] $1"; }
+
+log "Installing CloudWatch agent..."
+curl -fsSL -o /tmp/amazon-cloudwatch-agent.deb \
+    "https://amazoncloudwatch-agent.s3.amazonaws.com/ubuntu/$(dpkg --print-architecture)/latest/amazon-cloudwatch-agent.deb"
+dpkg -i /tmp/amazon-cloudwatch-agent.deb
+rm /tmp/amazon-cloudwatch-agent.deb
+cat > /opt/aws/amazon-cloudwatch-agent/etc/amazon-cloudwatch-agent.json <<'CWAGENT'
+{
+  "agent": { "metrics_collection_interval": 300 },
+  "metrics": {
+    "append_dimensions": { "InstanceId": "${aws:InstanceId}" },
+    "metrics_collected": {
+      "mem": { "measurement": ["mem_used_percent"] },
+      "disk": { "measurement": ["used_percent"], "resources": ["/"] }
+    }
+  }
+}
+CWAGENT
+/opt/aws/amazon-cloudwatch-agent/bin/amazon-cloudwatch-agent-ctl \
+    -a fetch-config -m ec2 -s -c file:/opt/aws/amazon-cloudwatch-agent/etc/amazon-cloudwatch-agent.json
+
+log "CloudWatch agent running."
@@ -0,0 +1,158 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// CreateSnapshot starts a crash-consistent snapshot of a volume, tagged for
+// slug. EC2 returns once the point in time is fixed; the copy completes in
+// the background.
+func (c *Client) CreateSnapshot(ctx context.Context, volumeID, slug string) (string, error) {
+	out, err := c.ec2.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+		VolumeId:    aws.String(volumeID),
+		Description: aws.String("crimata backup of " + slug),
+		TagSpecifications: []ec2types.TagSpecification{
+			{
+				ResourceType: ec2types.ResourceTypeSnapshot,
+				Tags: []ec2types.Tag{
+					{Key: aws.String("Name"), Value: aws.String("crimata-" + slug)},
+					{Key: aws.String("crimata:slug"), Value: aws.String(slug)},
+					{Key: aws.String("crimata:managed"), Value: aws.String("true")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("create snapshot: %w", err)
+	}
+	return aws.ToString(out.SnapshotId), nil
+}
+
+// WaitForSnapshot waits until a snapshot has completed.
+func (c *Client) WaitForSnapshot(ctx context.Context, snapshotID string, timeout time.Duration) error {
+	waiter := ec2.NewSnapshotCompletedWaiter(c.ec2)
+	return waiter.Wait(ctx, &ec2.DescribeSnapshotsInput{
+		SnapshotIds: []string{snapshotID},
+	}, timeout)
+}
+
+// CopySnapshot copies a completed snapshot from another region into the
+// client's, tagged for slug, and returns the copy's ID. Like CreateSnapshot
+// it returns before the copy has completed.
+func (c *Client) CopySnapshot(ctx context.Context, snapshotID, sourceRegion, slug string) (string, error) {
+	out, err := c.ec2.CopySnapshot(ctx, &ec2.CopySnapshotInput{
+		SourceSnapshotId: aws.String(snapshotID),
+		SourceRegion:     aws.String(sourceRegion),
+		Description:      aws.String(fmt.Sprintf("crimata backup of %s, copied from %s in %s", slug, snapshotID, sourceRegion)),
+		TagSpecifications: []ec2types.TagSpecification{
+			{
+				ResourceType: ec2types.ResourceTypeSnapshot,
+				Tags: []ec2types.Tag{
+					{Key: aws.String("Name"), Value: aws.String("crimata-" + slug)},
+					{Key: aws.String("crimata:slug"), Value: aws.String(slug)},
+					{Key: aws.String("crimata:managed"), Value: aws.String("true")},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("copy snapshot: %w", err)
+	}
+	return aws.ToString(out.SnapshotId), nil
+}
+
+func (c *Client) DeleteSnapshot(ctx context.Context, snapshotID string) error {
+	_, err := c.ec2.DeleteSnapshot(ctx, &ec2.DeleteSnapshotInput{
+		SnapshotId: aws.String(snapshotID),
+	})
+	return err
+}
+
+// ImageFromSnapshot registers a bootable AMI whose root volume is the
+// snapshot, copying architecture and boot settings from the base AMI the
+// snapshotted instance descends from. Deregister it once launched.
+func (c *Client) ImageFromSnapshot(ctx context.Context, snapshotID, name string) (string, error) {
+	base, err := c.ec2.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{c.cfg.AMI}})
+	if err != nil {
+		return "", fmt.Errorf("describe base image: %w", err)
+	}
+	if len(base.Images) == 0 {
+		return "", fmt.Errorf("base image %s not found", c.cfg.AMI)
+	}
+	tmpl := base.Images[0]
+
+	out, err := c.ec2.RegisterImage(ctx, &ec2.RegisterImageInput{
+		Name:               aws.String(name),
+		Description:        aws.String("crimata restore from " + snapshotID),
+		Architecture:       tmpl.Architecture,
+		BootMode:           ec2types.BootModeValues(tmpl.BootMode),
+		EnaSupport:         tmpl.EnaSupport,
+		RootDeviceName:     tmpl.RootDeviceName,
+		VirtualizationType: aws.String(string(tmpl.VirtualizationType)),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{
+				DeviceName: tmpl.RootDeviceName,
+				Ebs: &ec2types.EbsBlockDevice{
+					SnapshotId:          aws.String(snapshotID),
+					DeleteOnTermination: aws.Bool(true),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("register image: %w", err)
+	}
+	imageID := aws.ToString(out.ImageId)
+
+	waiter := ec2.NewImageAvailableWaiter(c.ec2)
+	if err := waiter.Wait(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{imageID},
+	}, 10*time.Minute); err != nil {
+		c.DeregisterImage(ctx, imageID)
+		return "", fmt.Errorf("wait for image %s: %w", imageID, err)
+	}
+	return imageID, nil
+}
+
+// DeregisterImage removes an AMI, leaving its snapshots in place.
+func (c *Client) DeregisterImage(ctx context.Context, imageID string) error {
+	_, err := c.ec2.DeregisterImage(ctx, &ec2.DeregisterImageInput{
+		ImageId: aws.String(imageID),
+	})
+	return err
+}
+
+// MoveElasticIP associates an Elastic IP with another instance, taking it
+// from whichever instance holds it now.
+func (c *Client) MoveElasticIP(ctx context.Context, allocationID, instanceID string) error {
+	_, err := c.ec2.AssociateAddress(ctx, &ec2.AssociateAddressInput{
+		AllocationId:       aws.String(allocationID),
+		InstanceId:         aws.String(instanceID),
+		AllowReassociation: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("associate address: %w", err)
+	}
+	return nil
+}
+
+// SnapshotState reports whether a snapshot is "pending", "completed" or
+// "error".
+func (c *Client) SnapshotState(ctx context.Context, snapshotID string) (string, error) {
+	out, err := c.ec2.DescribeSnapshots(ctx, &ec2.DescribeSnapshotsInput{
+		SnapshotIds: []string{snapshotID},
+	})
+	if err != nil {
+		return "", fmt.Errorf("describe snapshots: %w", err)
+	}
+	if len(out.Snapshots) == 0 {
+		return "", fmt.Errorf("snapshot %s not found", snapshotID)
+	}
+	return string(out.Snapshots[0].State), nil
+}
@@ -0,0 +1,103 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authorizedKeysFiles are where an instance's key may be authorized: root's
+// from user data, and ubuntu's, which dialSSH logs in as.
+const authorizedKeysFiles = "/root/.ssh/authorized_keys /home/ubuntu/.ssh/authorized_keys"
+
+// addKeyScript authorizes $2 in every file that authorizes $1.
+const addKeyScript = `set -euo pipefail
+found=0
+for f in ` + authorizedKeysFiles + `; do
+  [ -f "$f" ] && grep -qF "$1" "$f" || continue
+  grep -qF "$2" "$f" || echo "$2" >> "$f"
+  found=1
+done
+[ "$found" = 1 ] || { echo "current key is not authorized" >&2; exit 1; }
+`
+
+// removeKeyScript revokes $1 from every file that also authorizes $2,
+// rewriting in place to keep ownership and permissions.
+const removeKeyScript = `set -euo pipefail
+for f in ` + authorizedKeysFiles + `; do
+  [ -f "$f" ] && grep -qF "$2" "$f" || continue
+  grep -vF "$1" "$f" > "$f.tmp" || true
+  cat "$f.tmp" > "$f"
+  rm -f "$f.tmp"
+done
+`
+
+// AddSSHKey generates a new key, authorizes it alongside the current one
+// over the existing connection and confirms it can log in. The current key
+// keeps working until RemoveSSHKey.
+func (c *Client) AddSSHKey(ctx context.Context, host, privateKeyPEM string) (string, error) {
+	current, err := authorizedKey(privateKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	newKey, newPublic, err := generateSSHKeyPair()
+	if err != nil {
+		return "", fmt.Errorf("generate ssh key: %w", err)
+	}
+
+	client, err := dialSSH(ctx, host, privateKeyPEM, 30*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	if err := runScript(client, []byte(addKeyScript), current, strings.TrimSpace(newPublic)); err != nil {
+		return "", fmt.Errorf("install key: %w", err)
+	}
+
+	verify, err := dialSSH(ctx, host, newKey, 30*time.Second)
+	if err != nil {
+		// Don't leave an unused key authorized
+		runScript(client, []byte(removeKeyScript), strings.TrimSpace(newPublic), current)
+		return "", fmt.Errorf("verify new key: %w", err)
+	}
+	verify.Close()
+	return newKey, nil
+}
+
+// RemoveSSHKey revokes oldKeyPEM, connecting with privateKeyPEM so the
+// instance is never left without a working key.
+func (c *Client) RemoveSSHKey(ctx context.Context, host, privateKeyPEM, oldKeyPEM string) error {
+	current, err := authorizedKey(privateKeyPEM)
+	if err != nil {
+		return err
+	}
+	old, err := authorizedKey(oldKeyPEM)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialSSH(ctx, host, privateKeyPEM, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := runScript(client, []byte(removeKeyScript), old, current); err != nil {
+		return fmt.Errorf("remove key: %w", err)
+	}
+	return nil
+}
+
+// authorizedKey returns the authorized_keys entry for a private key, without
+// the trailing newline.
+func authorizedKey(privateKeyPEM string) (string, error) {
+	signer, err := parsePrivateKey(privateKeyPEM)
+	if err != nil {
+		return "", fmt.Errorf("parse private key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
@@ -0,0 +1,163 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// VolumeSpec configures an instance's root EBS volume. Zero fields keep the
+// AMI's defaults.
+type VolumeSpec struct {
+	SizeGB    int32
+	Type      string // e.g. "gp3"
+	IOPS      int32  // io1, io2 and gp3 only
+	Encrypted bool   // with the account's default EBS key
+}
+
+func (v VolumeSpec) empty() bool {
+	return v == VolumeSpec{}
+}
+
+// Volume is an instance's root EBS volume as it currently is.
+type Volume struct {
+	VolumeID  string `json:"volume_id"`
+	SizeGB    int32  `json:"size_gb"`
+	Type      string `json:"type"`
+	IOPS      int32  `json:"iops,omitempty"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// rootDeviceMapping maps spec onto imageID's root device, which RunInstances
+// needs by name.
+func (c *Client) rootDeviceMapping(ctx context.Context, imageID string, spec VolumeSpec) (ec2types.BlockDeviceMapping, error) {
+	out, err := c.ec2.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{imageID}})
+	if err != nil {
+		return ec2types.BlockDeviceMapping{}, fmt.Errorf("describe image: %w", err)
+	}
+	if len(out.Images) == 0 {
+		return ec2types.BlockDeviceMapping{}, fmt.Errorf("image %s not found", imageID)
+	}
+
+	ebs := &ec2types.EbsBlockDevice{DeleteOnTermination: aws.Bool(true)}
+	if spec.SizeGB > 0 {
+		ebs.VolumeSize = aws.Int32(spec.SizeGB)
+	}
+	if spec.Type != "" {
+		ebs.VolumeType = ec2types.VolumeType(spec.Type)
+	}
+	if spec.IOPS > 0 {
+		ebs.Iops = aws.Int32(spec.IOPS)
+	}
+	if spec.Encrypted {
+		ebs.Encrypted = aws.Bool(true)
+	}
+	return ec2types.BlockDeviceMapping{
+		DeviceName: out.Images[0].RootDeviceName,
+		Ebs:        ebs,
+	}, nil
+}
+
+// RootVolume looks up the EBS volume an instance boots from.
+func (c *Client) RootVolume(ctx context.Context, instanceID string) (*Volume, error) {
+	out, err := c.ec2.DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("describe instances: %w", err)
+	}
+	if len(out.Reservations) == 0 || len(out.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("instance %s not found", instanceID)
+	}
+
+	instance := out.Reservations[0].Instances[0]
+	var volumeID string
+	for _, m := range instance.BlockDeviceMappings {
+		if aws.ToString(m.DeviceName) == aws.ToString(instance.RootDeviceName) && m.Ebs != nil {
+			volumeID = aws.ToString(m.Ebs.VolumeId)
+		}
+	}
+	if volumeID == "" {
+		return nil, fmt.Errorf("instance %s has no root EBS volume", instanceID)
+	}
+
+	vols, err := c.ec2.DescribeVolumes(ctx, &ec2.DescribeVolumesInput{VolumeIds: []string{volumeID}})
+	if err != nil {
+		return nil, fmt.Errorf("describe volumes: %w", err)
+	}
+	if len(vols.Volumes) == 0 {
+		return nil, fmt.Errorf("volume %s not found", volumeID)
+	}
+	v := vols.Volumes[0]
+	return &Volume{
+		VolumeID:  volumeID,
+		SizeGB:    aws.ToInt32(v.Size),
+		Type:      string(v.VolumeType),
+		IOPS:      aws.ToInt32(v.Iops),
+		Encrypted: aws.ToBool(v.Encrypted),
+	}, nil
+}
+
+// ExpandVolume grows an EBS volume in place and waits until the new size is
+// usable. EC2 allows one modification per volume every six hours.
+func (c *Client) ExpandVolume(ctx context.Context, volumeID string, sizeGB int32) error {
+	if _, err := c.ec2.ModifyVolume(ctx, &ec2.ModifyVolumeInput{
+		VolumeId: aws.String(volumeID),
+		Size:     aws.Int32(sizeGB),
+	}); err != nil {
+		return fmt.Errorf("modify volume: %w", err)
+	}
+
+	// The filesystem can grow once the modification is optimizing; it
+	// doesn't have to wait for completed
+	deadline := time.Now().Add(10 * time.Minute)
+	for {
+		out, err := c.ec2.DescribeVolumesModifications(ctx, &ec2.DescribeVolumesModificationsInput{
+			VolumeIds: []string{volumeID},
+		})
+		if err != nil {
+			return fmt.Errorf("describe volume modifications: %w", err)
+		}
+		if len(out.VolumesModifications) > 0 {
+			m := out.VolumesModifications[0]
+			switch m.ModificationState {
+			case ec2types.VolumeModificationStateOptimizing, ec2types.VolumeModificationStateCompleted:
+				return nil
+			case ec2types.VolumeModificationStateFailed:
+				return fmt.Errorf("volume modification failed: %s", aws.ToString(m.StatusMessage))
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("volume %s modification timed out", volumeID)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// growFSScript extends the root partition and filesystem to fill the
+// volume. growpart exits 1 when there is nothing to grow.
+const growFSScript = `set -euo pipefail
+src=$(findmnt -no SOURCE /)
+disk=/dev/$(lsblk -no PKNAME "$src")
+part=$(cat "/sys/class/block/$(basename "$src")/partition")
+growpart "$disk" "$part" || [ $? -eq 1 ]
+case $(findmnt -no FSTYPE /) in
+  xfs) xfs_growfs / ;;
+  *)   resize2fs "$src" ;;
+esac
+df -h /
+`
+
+// GrowFilesystem resizes the root filesystem to fill its volume after
+// ExpandVolume, returning the resulting df output.
+func (c *Client) GrowFilesystem(ctx context.Context, t Target) (string, error) {
+	return c.Run(ctx, t, growFSScript)
+}
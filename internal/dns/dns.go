@@ -3,6 +3,11 @@ package dns
 import (
 	"context"
 	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
@@ -12,6 +17,15 @@ import (
 type Config struct {
 	HostedZoneID string
 	BaseDomain   string
+	SuspendedIP  string // landing page shown while an instance is suspended
+
+	// Resolver, if set (host:port), is queried after each change until the
+	// record resolves to its new value.
+	Resolver string
+
+	// PropagationTimeout bounds the wait for INSYNC and resolution. Defaults
+	// to 5 minutes.
+	PropagationTimeout time.Duration
 }
 
 type Client struct {
@@ -20,41 +34,225 @@ type Client struct {
 }
 
 func NewClient(awsCfg aws.Config, cfg Config) *Client {
+	if cfg.PropagationTimeout == 0 {
+		cfg.PropagationTimeout = 5 * time.Minute
+	}
 	return &Client{
 		r53: route53.NewFromConfig(awsCfg),
 		cfg: cfg,
 	}
 }
 
-// CreateRecord points slug.crimata.com at the EC2 public IP.
-func (c *Client) CreateRecord(ctx context.Context, slug, ip string) error {
-	return c.changeRecord(ctx, slug, ip, r53types.ChangeActionCreate)
+// CreateRecord points slug.crimata.com at the EC2 instance's public IPv4 (A)
+// and IPv6 (AAAA) addresses; either may be empty. It upserts, so repeating
+// it after a partial failure is safe.
+func (c *Client) CreateRecord(ctx context.Context, slug, ipv4, ipv6 string) error {
+	return c.setRecords(ctx, slug, ipv4, ipv6)
 }
 
-// DeleteRecord removes the Route53 record for a customer.
-func (c *Client) DeleteRecord(ctx context.Context, slug, ip string) error {
-	return c.changeRecord(ctx, slug, ip, r53types.ChangeActionDelete)
+// UpdateRecord repoints slug.crimata.com at new addresses, creating records
+// that don't exist and removing the A or AAAA record whose address is empty.
+func (c *Client) UpdateRecord(ctx context.Context, slug, ipv4, ipv6 string) error {
+	return c.setRecords(ctx, slug, ipv4, ipv6)
 }
 
-func (c *Client) changeRecord(ctx context.Context, slug, ip string, action r53types.ChangeAction) error {
-	name := fmt.Sprintf("%s.%s", slug, c.cfg.BaseDomain)
-	_, err := c.r53.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
-		HostedZoneId: aws.String(c.cfg.HostedZoneID),
-		ChangeBatch: &r53types.ChangeBatch{
-			Changes: []r53types.Change{
-				{
-					Action: action,
-					ResourceRecordSet: &r53types.ResourceRecordSet{
-						Name: aws.String(name),
-						Type: r53types.RRTypeA,
-						TTL:  aws.Int64(60),
-						ResourceRecords: []r53types.ResourceRecord{
-							{Value: aws.String(ip)},
-						},
+// PointAtLanding repoints slug.crimata.com at the "payment required" landing
+// page while the customer's instance is suspended.
+func (c *Client) PointAtLanding(ctx context.Context, slug string) error {
+	if c.cfg.SuspendedIP == "" {
+		return fmt.Errorf("no suspended landing IP configured")
+	}
+	return c.UpdateRecord(ctx, slug, c.cfg.SuspendedIP, "")
+}
+
+// DeleteRecord removes a customer's A and AAAA records, whatever they
+// currently point at. Deleting records that are already gone succeeds.
+func (c *Client) DeleteRecord(ctx context.Context, slug string) error {
+	return c.setRecords(ctx, slug, "", "")
+}
+
+// BaseDomain returns the zone customer hostnames live under.
+func (c *Client) BaseDomain() string {
+	return c.cfg.BaseDomain
+}
+
+// Hostname returns a customer's hostname, e.g. slug.crimata.com.
+func (c *Client) Hostname(slug string) string {
+	return fmt.Sprintf("%s.%s", slug, c.cfg.BaseDomain)
+}
+
+// setRecords makes slug's A and AAAA records hold exactly ipv4 and ipv6,
+// upserting non-empty addresses and deleting records for empty ones, then
+// waits until Route53 reports the change INSYNC and, if a resolver is
+// configured, until the addresses resolve.
+func (c *Client) setRecords(ctx context.Context, slug, ipv4, ipv6 string) error {
+	name := c.Hostname(slug)
+
+	var changes []r53types.Change
+	for _, rec := range []struct {
+		rrType r53types.RRType
+		ip     string
+	}{
+		{r53types.RRTypeA, ipv4},
+		{r53types.RRTypeAaaa, ipv6},
+	} {
+		if rec.ip != "" {
+			changes = append(changes, r53types.Change{
+				Action: r53types.ChangeActionUpsert,
+				ResourceRecordSet: &r53types.ResourceRecordSet{
+					Name: aws.String(name),
+					Type: rec.rrType,
+					TTL:  aws.Int64(60),
+					ResourceRecords: []r53types.ResourceRecord{
+						{Value: aws.String(rec.ip)},
 					},
 				},
+			})
+			continue
+		}
+
+		rrs, err := c.lookup(ctx, name, rec.rrType)
+		if err != nil {
+			return err
+		}
+		if rrs != nil {
+			changes = append(changes, r53types.Change{
+				Action:            r53types.ChangeActionDelete,
+				ResourceRecordSet: rrs,
+			})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := c.change(ctx, name, changes...); err != nil {
+		return err
+	}
+	return c.verifyResolves(ctx, name, ipv4, ipv6)
+}
+
+// change applies record set changes as one batch and waits for it to sync.
+func (c *Client) change(ctx context.Context, name string, changes ...r53types.Change) error {
+	out, err := c.r53.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(c.cfg.HostedZoneID),
+		ChangeBatch:  &r53types.ChangeBatch{Changes: changes},
+	})
+	if err != nil {
+		return fmt.Errorf("change %s: %w", name, err)
+	}
+
+	if err := c.waitForChange(ctx, out.ChangeInfo.Id); err != nil {
+		return fmt.Errorf("wait for %s: %w", name, err)
+	}
+	return nil
+}
+
+// lookup returns the current record set for name and type, or nil if there
+// is none.
+func (c *Client) lookup(ctx context.Context, name string, rrType r53types.RRType) (*r53types.ResourceRecordSet, error) {
+	out, err := c.r53.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(c.cfg.HostedZoneID),
+		StartRecordName: aws.String(name),
+		StartRecordType: rrType,
+		MaxItems:        aws.Int32(1),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", name, err)
+	}
+	for _, rrs := range out.ResourceRecordSets {
+		if strings.TrimSuffix(aws.ToString(rrs.Name), ".") == strings.TrimSuffix(name, ".") && rrs.Type == rrType {
+			return &rrs, nil
+		}
+	}
+	return nil, nil
+}
+
+// deleteRecordSet deletes rrs exactly as Route53 returned it, which is what
+// a DELETE change requires.
+func (c *Client) deleteRecordSet(ctx context.Context, rrs *r53types.ResourceRecordSet) error {
+	return c.change(ctx, aws.ToString(rrs.Name), r53types.Change{
+		Action:            r53types.ChangeActionDelete,
+		ResourceRecordSet: rrs,
+	})
+}
+
+// waitForChange blocks until Route53 has applied a change on all of its
+// authoritative name servers.
+func (c *Client) waitForChange(ctx context.Context, changeID *string) error {
+	waiter := route53.NewResourceRecordSetsChangedWaiter(c.r53)
+	return waiter.Wait(ctx, &route53.GetChangeInput{Id: changeID}, c.cfg.PropagationTimeout)
+}
+
+// verifyResolves polls the configured resolver until name resolves to every
+// non-empty address given.
+func (c *Client) verifyResolves(ctx context.Context, name string, ips ...string) error {
+	ips = slices.DeleteFunc(ips, func(ip string) bool { return ip == "" })
+	if c.cfg.Resolver == "" || len(ips) == 0 {
+		return nil
+	}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, c.cfg.Resolver)
+		},
+	}
+
+	deadline := time.Now().Add(c.cfg.PropagationTimeout)
+	for {
+		addrs, err := resolver.LookupHost(ctx, name)
+		if err == nil && resolvesTo(addrs, ips) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s does not resolve to %v via %s (got %v, %v)", name, ips, c.cfg.Resolver, addrs, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func resolvesTo(addrs, ips []string) bool {
+	for _, ip := range ips {
+		want := net.ParseIP(ip)
+		if !slices.ContainsFunc(addrs, func(a string) bool { return net.ParseIP(a).Equal(want) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// SetTXT writes a TXT record in the hosted zone, e.g. an ACME challenge,
+// and waits until Route53 has propagated it to its name servers.
+func (c *Client) SetTXT(ctx context.Context, name, value string) error {
+	return c.change(ctx, name, r53types.Change{
+		Action: r53types.ChangeActionUpsert,
+		ResourceRecordSet: &r53types.ResourceRecordSet{
+			Name: aws.String(name),
+			Type: r53types.RRTypeTxt,
+			TTL:  aws.Int64(60),
+			ResourceRecords: []r53types.ResourceRecord{
+				{Value: aws.String(strconv.Quote(value))},
 			},
 		},
 	})
-	return err
+}
+
+// DeleteTXT removes a TXT record written by SetTXT. It is a no-op if the
+// record is gone or now holds a different value.
+func (c *Client) DeleteTXT(ctx context.Context, name, value string) error {
+	rrs, err := c.lookup(ctx, name, r53types.RRTypeTxt)
+	if err != nil || rrs == nil {
+		return err
+	}
+	for _, rr := range rrs.ResourceRecords {
+		if aws.ToString(rr.Value) == strconv.Quote(value) {
+			return c.deleteRecordSet(ctx, rrs)
+		}
+	}
+	return nil
 }
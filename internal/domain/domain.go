@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPending  Status = "pending"  // waiting for the TXT verification record
+	StatusVerified Status = "verified" // ownership proven, not yet served
+	StatusActive   Status = "active"   // in nginx and on the instance's certificate
+	StatusFailed   Status = "failed"   // never verified
+)
+
+// Domain is a customer-owned hostname attached to an instance.
+type Domain struct {
+	ID                int64      `json:"id"`
+	InstanceID        int64      `json:"instance_id"`
+	Name              string     `json:"name"`
+	Status            Status     `json:"status"`
+	VerificationToken string     `json:"verification_token"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+
+	// Failed certificate issuances since verification, and when to try next
+	ActivationAttempts int        `json:"activation_attempts"`
+	NextAttemptAt      *time.Time `json:"next_attempt_at,omitempty"`
+}
+
+// VerificationName is the TXT record the customer must publish.
+func (d *Domain) VerificationName() string {
+	return "_crimata-verify." + d.Name
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// migrations add columns introduced after the domains table was created.
+var migrations = []string{
+	`ALTER TABLE domains ADD COLUMN IF NOT EXISTS activation_attempts INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE domains ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ`,
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS domains (
+			id                  SERIAL PRIMARY KEY,
+			instance_id         INTEGER NOT NULL REFERENCES instances(id),
+			name                TEXT UNIQUE NOT NULL,
+			status              TEXT NOT NULL DEFAULT 'pending',
+			verification_token  TEXT NOT NULL,
+			verified_at         TIMESTAMPTZ,
+			created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := s.db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Create(d *Domain) error {
+	return s.db.QueryRow(`
+		INSERT INTO domains (instance_id, name, status, verification_token)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		d.InstanceID, d.Name, d.Status, d.VerificationToken,
+	).Scan(&d.ID, &d.CreatedAt)
+}
+
+const columns = `id, instance_id, name, status, verification_token, verified_at, created_at,
+	activation_attempts, next_attempt_at`
+
+func (s *Store) query(where string, args ...any) ([]*Domain, error) {
+	rows, err := s.db.Query(`SELECT `+columns+` FROM domains WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []*Domain
+	for rows.Next() {
+		d := &Domain{}
+		if err := rows.Scan(
+			&d.ID, &d.InstanceID, &d.Name, &d.Status,
+			&d.VerificationToken, &d.VerifiedAt, &d.CreatedAt,
+			&d.ActivationAttempts, &d.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+func (s *Store) GetByName(name string) (*Domain, error) {
+	domains, err := s.query(`name = $1`, name)
+	if err != nil || len(domains) == 0 {
+		return nil, err
+	}
+	return domains[0], nil
+}
+
+func (s *Store) ListByInstance(instanceID int64) ([]*Domain, error) {
+	return s.query(`instance_id = $1`, instanceID)
+}
+
+// ListByStatus returns domains in the given status across all instances.
+func (s *Store) ListByStatus(status Status) ([]*Domain, error) {
+	return s.query(`status = $1`, status)
+}
+
+func (s *Store) UpdateStatus(id int64, status Status) error {
+	_, err := s.db.Exec(`UPDATE domains SET status = $1 WHERE id = $2`, status, id)
+	return err
+}
+
+func (s *Store) MarkVerified(id int64) error {
+	_, err := s.db.Exec(
+		`UPDATE domains SET status = $1, verified_at = NOW() WHERE id = $2`,
+		StatusVerified, id,
+	)
+	return err
+}
+
+// DeferActivation records a failed activation and when to retry it.
+func (s *Store) DeferActivation(id int64, next time.Time) error {
+	_, err := s.db.Exec(
+		`UPDATE domains SET activation_attempts = activation_attempts + 1, next_attempt_at = $1 WHERE id = $2`,
+		next, id,
+	)
+	return err
+}
+
+func (s *Store) Delete(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM domains WHERE id = $1`, id)
+	return err
+}
@@ -2,17 +2,31 @@ package export
 
 import (
 	"context"
+	_ "embed"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 )
 
+// MaxLinkExpiry is the longest lifetime S3 allows for a pre-signed URL.
+const MaxLinkExpiry = 7 * 24 * time.Hour
+
+//go:embed export.sh
+var exportScript string
+
 type Config struct {
 	S3Bucket string
 	Region   string
+	Endpoint string // optional S3-compatible endpoint, e.g. a local MinIO
+
+	// LinkExpiry is the default lifetime of download links. Defaults to 24h.
+	LinkExpiry time.Duration
 }
 
 type Client struct {
@@ -22,26 +36,37 @@ type Client struct {
 }
 
 func NewClient(awsCfg aws.Config, cfg Config) *Client {
+	if cfg.LinkExpiry == 0 {
+		cfg.LinkExpiry = 24 * time.Hour
+	}
 	return &Client{
-		s3:  s3.NewFromConfig(awsCfg),
+		s3: s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			if cfg.Endpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.Endpoint)
+				o.UsePathStyle = true
+			}
+		}),
 		ssm: ssm.NewFromConfig(awsCfg),
 		cfg: cfg,
 	}
 }
 
-// Export dumps the customer's Postgres DB and files, uploads to S3,
-// and returns a pre-signed download URL valid for 24 hours.
-func (c *Client) Export(ctx context.Context, instanceID, slug string) (string, error) {
+// Export dumps the customer's Postgres databases and the given directories
+// under /opt/crimata, uploads them to S3 with a checksum manifest, verifies
+// the upload and returns the archive's S3 key.
+func (c *Client) Export(ctx context.Context, region, instanceID, slug string, dirs []string) (string, error) {
 	key := fmt.Sprintf("exports/%s-%d.tar.gz", slug, time.Now().Unix())
 
-	// Run export script on the EC2 via SSM
-	script := fmt.Sprintf(`
-		pg_dump crimata > /tmp/crimata.sql
-		tar -czf /tmp/export.tar.gz /tmp/crimata.sql /opt/crimata/data
-		aws s3 cp /tmp/export.tar.gz s3://%s/%s
-	`, c.cfg.S3Bucket, key)
+	// Run export script on the EC2 via SSM, in the region the instance runs
+	// in; the upload goes to the bucket's region wherever that is
+	script := fmt.Sprintf("cat > /tmp/crimata-export.sh << 'CRIMATA_EOF'\n%s\nCRIMATA_EOF\nAWS_DEFAULT_REGION=%s bash /tmp/crimata-export.sh %s %s %s %s %s",
+		exportScript, c.cfg.Region, slug, c.cfg.S3Bucket, key, ManifestKey(key), strings.Join(dirs, " "))
 
-	_, err := c.ssm.SendCommand(ctx, &ssm.SendCommandInput{
+	client := c.ssm
+	if region != "" {
+		client = ssm.New(c.ssm.Options(), func(o *ssm.Options) { o.Region = region })
+	}
+	out, err := client.SendCommand(ctx, &ssm.SendCommandInput{
 		InstanceIds:  []string{instanceID},
 		DocumentName: aws.String("AWS-RunShellScript"),
 		Parameters:   map[string][]string{"commands": {script}},
@@ -50,15 +75,52 @@ func (c *Client) Export(ctx context.Context, instanceID, slug string) (string, e
 		return "", fmt.Errorf("export script: %w", err)
 	}
 
-	// Generate pre-signed URL valid for 24 hours
+	// Wait for the upload to finish before checking it
+	waiter := ssm.NewCommandExecutedWaiter(client)
+	if err := waiter.Wait(ctx, &ssm.GetCommandInvocationInput{
+		CommandId:  out.Command.CommandId,
+		InstanceId: aws.String(instanceID),
+	}, 30*time.Minute); err != nil {
+		return "", fmt.Errorf("export script: %w", err)
+	}
+
+	if _, err := c.Verify(ctx, key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Presign returns a download URL for an archive. A zero expiry uses the
+// configured default; anything longer than MaxLinkExpiry is capped.
+func (c *Client) Presign(ctx context.Context, key string, expiry time.Duration) (string, time.Duration, error) {
+	if expiry <= 0 {
+		expiry = c.cfg.LinkExpiry
+	}
+	if expiry > MaxLinkExpiry {
+		expiry = MaxLinkExpiry
+	}
+
 	presigner := s3.NewPresignClient(c.s3)
 	req, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(c.cfg.S3Bucket),
 		Key:    aws.String(key),
-	}, s3.WithPresignExpires(24*time.Hour))
+	}, s3.WithPresignExpires(expiry))
 	if err != nil {
-		return "", fmt.Errorf("presign: %w", err)
+		return "", 0, fmt.Errorf("presign: %w", err)
 	}
+	return req.URL, expiry, nil
+}
 
-	return req.URL, nil
+// Exists reports whether an archive is still retained in S3. The bucket's
+// lifecycle rule removes exports after 30 days.
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := c.s3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.cfg.S3Bucket),
+		Key:    aws.String(key),
+	})
+	var notFound *s3types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
 }
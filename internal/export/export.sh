@@ -0,0 +1,102 @@
+#!/bin/bash
+# export.sh — packages customer data and a checksum manifest into S3
+# Usage: export.sh <slug> <s3_bucket> <archive_key> <manifest_key> [dir...]
+# Each dir is relative to /opt/crimata; defaults to "data".
+
+set -euo pipefail
+
+SLUG=$1
+S3_BUCKET=$2
+ARCHIVE_KEY=$3
+MANIFEST_KEY=$4
+shift 4
+DIRS=("${@:-data}")
+CRIMATA_DIR=/opt/crimata
+STAGE=/tmp/crimata-export
+
+log() { echo "[crimata] $1"; }
+
+rm -rf "$STAGE" /tmp/export.tar.gz /tmp/export.manifest.json
+mkdir -p "$STAGE/databases"
+
+# ── 1. Dump every database ──────────────────────────────────────────────────
+log "Dumping databases..."
+for DB in $(su -c "psql -Atc \"SELECT datname FROM pg_database WHERE NOT datistemplate AND datname <> 'postgres'\"" postgres); do
+    su -c "pg_dump $DB" postgres > "$STAGE/databases/$DB.sql"
+done
+
+# ── 2. Copy files ───────────────────────────────────────────────────────────
+log "Copying data..."
+for DIR in "${DIRS[@]}"; do
+    mkdir -p "$STAGE/$DIR"
+    if [ -d "$CRIMATA_DIR/$DIR" ]; then
+        cp -a "$CRIMATA_DIR/$DIR/." "$STAGE/$DIR/"
+    fi
+done
+
+# ── 3. Manifest ─────────────────────────────────────────────────────────────
+# Packed inside the archive, listing every file with its size and SHA-256.
+log "Writing manifest..."
+python3 - "$STAGE" "$SLUG" > /tmp/manifest.json << 'EOF'
+import datetime, hashlib, json, os, sys
+
+stage, slug = sys.argv[1], sys.argv[2]
+
+def sha256(path):
+    h = hashlib.sha256()
+    with open(path, "rb") as f:
+        for chunk in iter(lambda: f.read(1 << 20), b""):
+            h.update(chunk)
+    return h.hexdigest()
+
+files = []
+for root, _, names in os.walk(stage):
+    for name in sorted(names):
+        full = os.path.join(root, name)
+        if os.path.islink(full):
+            continue
+        rel = os.path.relpath(full, stage)
+        entry = {"path": rel, "size": os.path.getsize(full), "sha256": sha256(full)}
+        if rel.startswith("databases/") and rel.endswith(".sql"):
+            entry["database"] = name[:-len(".sql")]
+        files.append(entry)
+
+json.dump({
+    "version": 1,
+    "slug": slug,
+    "created_at": datetime.datetime.now(datetime.timezone.utc).strftime("%Y-%m-%dT%H:%M:%SZ"),
+    "files": sorted(files, key=lambda f: f["path"]),
+}, sys.stdout, indent=2)
+EOF
+mv /tmp/manifest.json "$STAGE/manifest.json"
+
+# ── 4. Package ──────────────────────────────────────────────────────────────
+log "Packaging data..."
+tar -czf /tmp/export.tar.gz -C "$STAGE" .
+
+# ── 5. Archive checksum ─────────────────────────────────────────────────────
+# The copy stored next to the archive also records the tarball's own hash.
+python3 - "$STAGE/manifest.json" /tmp/export.tar.gz "$ARCHIVE_KEY" > /tmp/export.manifest.json << 'EOF'
+import hashlib, json, os, sys
+
+manifest_path, archive, key = sys.argv[1], sys.argv[2], sys.argv[3]
+
+h = hashlib.sha256()
+with open(archive, "rb") as f:
+    for chunk in iter(lambda: f.read(1 << 20), b""):
+        h.update(chunk)
+
+with open(manifest_path) as f:
+    manifest = json.load(f)
+manifest["archive"] = {"key": key, "size": os.path.getsize(archive), "sha256": h.hexdigest()}
+json.dump(manifest, sys.stdout, indent=2)
+EOF
+
+# ── 6. Upload to S3 ─────────────────────────────────────────────────────────
+log "Uploading to S3..."
+aws s3 cp /tmp/export.tar.gz "s3://$S3_BUCKET/$ARCHIVE_KEY"
+aws s3 cp /tmp/export.manifest.json "s3://$S3_BUCKET/$MANIFEST_KEY" --content-type application/json
+
+rm -rf "$STAGE" /tmp/export.tar.gz /tmp/export.manifest.json
+
+log "Export complete: s3://$S3_BUCKET/$ARCHIVE_KEY"
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// manifestName is the manifest's path inside the archive. It is not listed
+// in its own file table.
+const manifestName = "manifest.json"
+
+// Manifest describes the contents of an export archive. A copy is packed
+// inside the tarball; the copy stored next to it in S3 also carries the
+// archive's own checksum.
+type Manifest struct {
+	Version   int       `json:"version"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+	Archive   *Archive  `json:"archive,omitempty"`
+	Files     []File    `json:"files"`
+}
+
+type Archive struct {
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type File struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Database string `json:"database,omitempty"` // set for Postgres dumps
+}
+
+// ManifestKey returns the S3 key of the manifest written alongside an archive.
+func ManifestKey(archiveKey string) string {
+	return strings.TrimSuffix(archiveKey, ".tar.gz") + ".manifest.json"
+}
+
+// Verify downloads an export and its manifest from S3 and checks every file
+// in the archive against the recorded sizes and SHA-256 sums.
+func (c *Client) Verify(ctx context.Context, archiveKey string) (*Manifest, error) {
+	m, err := c.Manifest(ctx, archiveKey)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.cfg.S3Bucket),
+		Key:    aws.String(archiveKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get archive: %w", err)
+	}
+	defer obj.Body.Close()
+
+	if err := VerifyArchive(obj.Body, m); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// Manifest fetches the manifest stored alongside an archive.
+func (c *Client) Manifest(ctx context.Context, archiveKey string) (*Manifest, error) {
+	obj, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.cfg.S3Bucket),
+		Key:    aws.String(ManifestKey(archiveKey)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get manifest: %w", err)
+	}
+	defer obj.Body.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(obj.Body).Decode(m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	return m, nil
+}
+
+// VerifyArchive reads a gzipped tarball and compares it against m. Missing,
+// unexpected and mismatched files are all reported in the returned error.
+func VerifyArchive(r io.Reader, m *Manifest) error {
+	archiveHash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(r, archiveHash)}
+
+	gz, err := gzip.NewReader(counter)
+	if err != nil {
+		return fmt.Errorf("open archive: %w", err)
+	}
+	defer gz.Close()
+
+	expected := make(map[string]File, len(m.Files))
+	for _, f := range m.Files {
+		expected[path.Clean(f.Path)] = f
+	}
+
+	var problems []error
+	seen := make(map[string]bool, len(m.Files))
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if name == manifestName {
+			continue
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(h, tr)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+
+		want, ok := expected[name]
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s: not in manifest", name))
+			continue
+		}
+		seen[name] = true
+		if n != want.Size {
+			problems = append(problems, fmt.Errorf("%s: size %d, manifest says %d", name, n, want.Size))
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != want.SHA256 {
+			problems = append(problems, fmt.Errorf("%s: sha256 %s, manifest says %s", name, sum, want.SHA256))
+		}
+	}
+
+	for name := range expected {
+		if !seen[name] {
+			problems = append(problems, fmt.Errorf("%s: missing from archive", name))
+		}
+	}
+
+	// Drain any gzip trailer so the archive hash covers the whole object
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	if m.Archive != nil {
+		if counter.n != m.Archive.Size {
+			problems = append(problems, fmt.Errorf("archive: size %d, manifest says %d", counter.n, m.Archive.Size))
+		}
+		if sum := hex.EncodeToString(archiveHash.Sum(nil)); sum != m.Archive.SHA256 {
+			problems = append(problems, fmt.Errorf("archive: sha256 %s, manifest says %s", sum, m.Archive.SHA256))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("export verification failed: %w", errors.Join(problems...))
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,141 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildArchive packs files, plus a manifest describing them, into a gzipped
+// tarball the way export.sh does and returns it with the manifest.
+func buildArchive(t *testing.T, files map[string]string) ([]byte, *Manifest) {
+	t.Helper()
+	m := &Manifest{Version: 1, Slug: "acme", CreatedAt: time.Unix(1700000000, 0).UTC()}
+	for name, content := range files {
+		sum := sha256.Sum256([]byte(content))
+		m.Files = append(m.Files, File{Path: name, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])})
+	}
+	return pack(t, files, m), m
+}
+
+func pack(t *testing.T, files map[string]string, m *Manifest) []byte {
+	t.Helper()
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	add := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(manifestName, manifest)
+	for name, content := range files {
+		add(name, []byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// withArchive records the archive's own size and checksum, as the copy of
+// the manifest stored in S3 does.
+func withArchive(m *Manifest, data []byte) *Manifest {
+	sum := sha256.Sum256(data)
+	out := *m
+	out.Archive = &Archive{Key: "exports/acme.tar.gz", Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	return &out
+}
+
+var testFiles = map[string]string{
+	"db/crimata.sql":        "CREATE TABLE contacts (id int);\n",
+	"files/uploads/a.txt":   "hello",
+	"files/uploads/b/c.bin": "\x00\x01\x02",
+}
+
+func TestVerifyArchiveGood(t *testing.T) {
+	data, m := buildArchive(t, testFiles)
+	if err := VerifyArchive(bytes.NewReader(data), withArchive(m, data)); err != nil {
+		t.Fatalf("VerifyArchive: %v", err)
+	}
+}
+
+func TestVerifyArchiveTamperedFile(t *testing.T) {
+	_, m := buildArchive(t, testFiles)
+	tampered := map[string]string{}
+	for k, v := range testFiles {
+		tampered[k] = v
+	}
+	tampered["files/uploads/a.txt"] = "jello"
+	data := pack(t, tampered, m)
+
+	err := VerifyArchive(bytes.NewReader(data), m)
+	if err == nil || !strings.Contains(err.Error(), "files/uploads/a.txt: sha256") {
+		t.Fatalf("VerifyArchive = %v, want checksum mismatch for a.txt", err)
+	}
+}
+
+func TestVerifyArchiveTamperedArchive(t *testing.T) {
+	data, m := buildArchive(t, testFiles)
+	stored := withArchive(m, data)
+	stored.Archive.SHA256 = strings.Repeat("0", 64)
+
+	err := VerifyArchive(bytes.NewReader(data), stored)
+	if err == nil || !strings.Contains(err.Error(), "archive: sha256") {
+		t.Fatalf("VerifyArchive = %v, want archive checksum mismatch", err)
+	}
+}
+
+func TestVerifyArchiveMissingFile(t *testing.T) {
+	_, m := buildArchive(t, testFiles)
+	partial := map[string]string{}
+	for k, v := range testFiles {
+		if k != "db/crimata.sql" {
+			partial[k] = v
+		}
+	}
+	data := pack(t, partial, m)
+
+	err := VerifyArchive(bytes.NewReader(data), m)
+	if err == nil || !strings.Contains(err.Error(), "db/crimata.sql: missing from archive") {
+		t.Fatalf("VerifyArchive = %v, want missing db/crimata.sql", err)
+	}
+}
+
+func TestVerifyArchiveExtraFile(t *testing.T) {
+	_, m := buildArchive(t, testFiles)
+	extra := map[string]string{"files/uploads/stowaway": "x"}
+	for k, v := range testFiles {
+		extra[k] = v
+	}
+	data := pack(t, extra, m)
+
+	err := VerifyArchive(bytes.NewReader(data), m)
+	if err == nil || !strings.Contains(err.Error(), "files/uploads/stowaway: not in manifest") {
+		t.Fatalf("VerifyArchive = %v, want unexpected stowaway", err)
+	}
+}
+
+func TestVerifyArchiveNotGzip(t *testing.T) {
+	_, m := buildArchive(t, testFiles)
+	if err := VerifyArchive(strings.NewReader("not an archive"), m); err == nil {
+		t.Fatal("VerifyArchive accepted a non-gzip stream")
+	}
+}
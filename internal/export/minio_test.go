@@ -0,0 +1,100 @@
+//go:build minio
+
+// Runs Verify against a local S3 stand-in:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	MINIO_ENDPOINT=http://localhost:9000 go test -tags minio ./internal/export
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func minioClient(t *testing.T) *Client {
+	t.Helper()
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT not set")
+	}
+	accessKey, secretKey := envOr("MINIO_ACCESS_KEY", "minioadmin"), envOr("MINIO_SECRET_KEY", "minioadmin")
+	awsCfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey}, nil
+		}),
+	}
+	c := NewClient(awsCfg, Config{
+		S3Bucket: envOr("MINIO_BUCKET", "crimata-exports-test"),
+		Region:   "us-east-1",
+		Endpoint: endpoint,
+	})
+
+	ctx := context.Background()
+	if _, err := c.s3.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.cfg.S3Bucket)}); err != nil {
+		if _, err := c.s3.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(c.cfg.S3Bucket)}); err != nil {
+			t.Fatalf("create bucket: %v", err)
+		}
+	}
+	return c
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func upload(t *testing.T, c *Client, key string, body []byte) {
+	t.Helper()
+	if _, err := c.s3.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(c.cfg.S3Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	}); err != nil {
+		t.Fatalf("put %s: %v", key, err)
+	}
+}
+
+func TestVerifyMinIO(t *testing.T) {
+	c := minioClient(t)
+	ctx := context.Background()
+	key := fmt.Sprintf("exports/acme/%d.tar.gz", time.Now().UnixNano())
+
+	data, m := buildArchive(t, testFiles)
+	stored := withArchive(m, data)
+	stored.Archive.Key = key
+	manifest, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload(t, c, key, data)
+	upload(t, c, ManifestKey(key), manifest)
+
+	got, err := c.Verify(ctx, key)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if got.Archive == nil || got.Archive.SHA256 != stored.Archive.SHA256 {
+		t.Fatalf("Verify returned manifest %+v, want archive %+v", got.Archive, stored.Archive)
+	}
+
+	// Overwrite the archive with different contents under the same manifest
+	tampered := map[string]string{}
+	for k, v := range testFiles {
+		tampered[k] = v + "!"
+	}
+	upload(t, c, key, pack(t, tampered, m))
+	if _, err := c.Verify(ctx, key); err == nil {
+		t.Fatal("Verify accepted a tampered archive")
+	}
+}
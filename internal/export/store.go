@@ -0,0 +1,82 @@
+package export
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Record is an export archive retained in S3.
+type Record struct {
+	ID         int64     `json:"id"`
+	InstanceID int64     `json:"instance_id"`
+	Slug       string    `json:"slug"`
+	S3Key      string    `json:"s3_key"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS exports (
+			id           SERIAL PRIMARY KEY,
+			instance_id  INTEGER NOT NULL REFERENCES instances(id),
+			slug         TEXT NOT NULL,
+			s3_key       TEXT UNIQUE NOT NULL,
+			created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	return err
+}
+
+func (s *Store) Create(rec *Record) error {
+	return s.db.QueryRow(`
+		INSERT INTO exports (instance_id, slug, s3_key)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`,
+		rec.InstanceID, rec.Slug, rec.S3Key,
+	).Scan(&rec.ID, &rec.CreatedAt)
+}
+
+func (s *Store) Get(id int64) (*Record, error) {
+	rec := &Record{}
+	err := s.db.QueryRow(`
+		SELECT id, instance_id, slug, s3_key, created_at
+		FROM exports WHERE id = $1`,
+		id,
+	).Scan(&rec.ID, &rec.InstanceID, &rec.Slug, &rec.S3Key, &rec.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rec, err
+}
+
+// ListByInstance returns an instance's exports, newest first.
+func (s *Store) ListByInstance(instanceID int64) ([]*Record, error) {
+	rows, err := s.db.Query(`
+		SELECT id, instance_id, slug, s3_key, created_at
+		FROM exports WHERE instance_id = $1
+		ORDER BY created_at DESC`,
+		instanceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []*Record
+	for rows.Next() {
+		rec := &Record{}
+		if err := rows.Scan(&rec.ID, &rec.InstanceID, &rec.Slug, &rec.S3Key, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultPaths are the endpoints nginx serves on every hub, as configured by
+// provision.sh: the UI, auth, dock and contacts app.
+var DefaultPaths = []string{"/", "/auth/", "/dock/", "/apps/contacts/"}
+
+// Check is the result of probing one path on one instance.
+type Check struct {
+	InstanceID int64     `json:"-"`
+	Path       string    `json:"path"`
+	OK         bool      `json:"ok"`
+	StatusCode int       `json:"status_code,omitempty"`
+	LatencyMS  int64     `json:"latency_ms"`
+	Error      string    `json:"error,omitempty"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+// Prober checks a hub's HTTP endpoints. Any response below 500 counts as
+// healthy: auth and apps legitimately answer 401 or 404 to a bare request.
+type Prober struct {
+	client *http.Client
+	paths  []string
+}
+
+func NewProber(paths []string, timeout time.Duration) *Prober {
+	if len(paths) == 0 {
+		paths = DefaultPaths
+	}
+	return &Prober{
+		client: &http.Client{
+			Timeout: timeout,
+			// A redirect is an answer; don't follow it to the login page
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		paths: paths,
+	}
+}
+
+// Probe requests every path concurrently over plain HTTP on host, sending
+// hostname as the Host header so nginx picks the hub's server block.
+func (p *Prober) Probe(ctx context.Context, instanceID int64, host, hostname string) []Check {
+	checks := make([]Check, len(p.paths))
+	var wg sync.WaitGroup
+	for i, path := range p.paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			checks[i] = p.probe(ctx, host, hostname, path)
+			checks[i].InstanceID = instanceID
+		}(i, path)
+	}
+	wg.Wait()
+	return checks
+}
+
+func (p *Prober) probe(ctx context.Context, host, hostname, path string) Check {
+	c := Check{Path: path, CheckedAt: time.Now()}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(host, "80")+path, nil)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	req.Host = hostname
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	c.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	resp.Body.Close()
+
+	c.StatusCode = resp.StatusCode
+	c.OK = resp.StatusCode < 500
+	if !c.OK {
+		c.Error = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	return c
+}
+
+// Healthy reports whether every check passed.
+func Healthy(checks []Check) bool {
+	for _, c := range checks {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
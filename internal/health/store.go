@@ -0,0 +1,84 @@
+package health
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS health_checks (
+			id           BIGSERIAL PRIMARY KEY,
+			instance_id  BIGINT NOT NULL,
+			path         TEXT NOT NULL,
+			ok           BOOLEAN NOT NULL,
+			status_code  INTEGER NOT NULL DEFAULT 0,
+			latency_ms   BIGINT NOT NULL,
+			error        TEXT NOT NULL DEFAULT '',
+			checked_at   TIMESTAMPTZ NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS health_checks_instance_idx ON health_checks (instance_id, checked_at DESC)
+	`)
+	return err
+}
+
+func (s *Store) Record(checks []Check) error {
+	for _, c := range checks {
+		if _, err := s.db.Exec(`
+			INSERT INTO health_checks (instance_id, path, ok, status_code, latency_ms, error, checked_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			c.InstanceID, c.Path, c.OK, c.StatusCode, c.LatencyMS, c.Error, c.CheckedAt,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Latest returns the most recent check of each path for an instance.
+func (s *Store) Latest(instanceID int64) ([]Check, error) {
+	return s.list(`
+		SELECT DISTINCT ON (path) instance_id, path, ok, status_code, latency_ms, error, checked_at
+		FROM health_checks WHERE instance_id = $1
+		ORDER BY path, checked_at DESC`, instanceID)
+}
+
+// Since returns an instance's checks after a point in time, newest first.
+func (s *Store) Since(instanceID int64, since time.Time) ([]Check, error) {
+	return s.list(`
+		SELECT instance_id, path, ok, status_code, latency_ms, error, checked_at
+		FROM health_checks WHERE instance_id = $1 AND checked_at > $2
+		ORDER BY checked_at DESC, path`, instanceID, since)
+}
+
+func (s *Store) list(query string, args ...any) ([]Check, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checks []Check
+	for rows.Next() {
+		var c Check
+		if err := rows.Scan(&c.InstanceID, &c.Path, &c.OK, &c.StatusCode, &c.LatencyMS, &c.Error, &c.CheckedAt); err != nil {
+			return nil, err
+		}
+		checks = append(checks, c)
+	}
+	return checks, rows.Err()
+}
+
+// Prune deletes checks older than before.
+func (s *Store) Prune(before time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM health_checks WHERE checked_at < $1`, before)
+	return err
+}
@@ -0,0 +1,101 @@
+package image
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/adgundersen/crimata-infra/internal/compute"
+)
+
+// Image is a golden AMI with the Crimata OS base install baked in.
+type Image struct {
+	ID        int64     `json:"id"`
+	Version   string    `json:"version"`
+	AMIID     string    `json:"ami_id"`
+	SourceAMI string    `json:"source_ami"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS images (
+			id          BIGSERIAL PRIMARY KEY,
+			version     TEXT UNIQUE NOT NULL,
+			ami_id      TEXT NOT NULL,
+			source_ami  TEXT NOT NULL,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	return err
+}
+
+func (s *Store) Create(img *Image) error {
+	return s.db.QueryRow(`
+		INSERT INTO images (version, ami_id, source_ami)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at`,
+		img.Version, img.AMIID, img.SourceAMI,
+	).Scan(&img.ID, &img.CreatedAt)
+}
+
+// Latest returns the most recently built image, or nil if none exists yet.
+func (s *Store) Latest() (*Image, error) {
+	img := &Image{}
+	err := s.db.QueryRow(`
+		SELECT id, version, ami_id, source_ami, created_at
+		FROM images ORDER BY created_at DESC, id DESC LIMIT 1`,
+	).Scan(&img.ID, &img.Version, &img.AMIID, &img.SourceAMI, &img.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return img, err
+}
+
+// ── Building ──────────────────────────────────────────────────────────────────
+
+// Build boots a builder instance from the base AMI, runs the base install,
+// snapshots it as version and records the result. The builder is always
+// terminated.
+func Build(ctx context.Context, c *compute.Client, store *Store, version string) (*Image, error) {
+	builder, err := c.Launch(ctx, compute.LaunchSpec{Slug: "image-builder-" + version})
+	if err != nil {
+		return nil, fmt.Errorf("launch builder: %w", err)
+	}
+	defer func() {
+		if err := c.Terminate(context.Background(), builder.InstanceID); err != nil {
+			fmt.Printf("image: terminate builder %s failed: %v\n", builder.InstanceID, err)
+		}
+	}()
+
+	fmt.Printf("image: builder %s launched for %s\n", builder.InstanceID, version)
+	ready, err := c.WaitUntilReady(ctx, builder.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("wait for builder: %w", err)
+	}
+
+	if err := c.Bake(ctx, ready.Host(), builder.SSHPrivateKey); err != nil {
+		return nil, fmt.Errorf("bake: %w", err)
+	}
+
+	fmt.Printf("image: creating AMI for %s\n", version)
+	amiID, err := c.CreateImage(ctx, builder.InstanceID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{Version: version, AMIID: amiID, SourceAMI: c.BaseAMI()}
+	if err := store.Create(img); err != nil {
+		return nil, fmt.Errorf("record image %s: %w", amiID, err)
+	}
+	return img, nil
+}
@@ -3,26 +3,54 @@ package instance
 import (
 	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Status string
 
 const (
-	StatusProvisioning Status = "provisioning"
-	StatusActive       Status = "active"
-	StatusFailed       Status = "failed"
-	StatusCancelled    Status = "cancelled"
+	StatusProvisioning    Status = "provisioning"
+	StatusActive          Status = "active"
+	StatusFailed          Status = "failed"
+	StatusCancelled       Status = "cancelled"
+	StatusSuspending      Status = "suspending"
+	StatusSuspended       Status = "suspended"
+	StatusResuming        Status = "resuming"
+	StatusPendingDeletion Status = "pending_deletion" // suspended, deprovisioned at DeletionScheduledAt
+	StatusResizing        Status = "resizing"
+	StatusUpgrading       Status = "upgrading"
+	StatusDegraded        Status = "degraded"  // running but failing health checks
+	StatusRestoring       Status = "restoring" // moving onto a replacement EC2 instance
+	StatusMigrating       Status = "migrating" // services stopped while moving to a new host
+	StatusRotatingKey     Status = "rotating_key"
 )
 
 type Instance struct {
-	ID               int64     `json:"id"`
-	StripeCustomerID string    `json:"stripe_customer_id"`
-	Slug             string    `json:"slug"`
-	EC2InstanceID    string    `json:"ec2_instance_id"`
-	EC2PublicIP      string    `json:"ec2_public_ip"`
-	SSHPrivateKey    string    `json:"-"`
-	Status           Status    `json:"status"`
-	CreatedAt        time.Time `json:"created_at"`
+	ID                   int64      `json:"id"`
+	StripeCustomerID     string     `json:"stripe_customer_id"`
+	Email                string     `json:"email"`
+	Slug                 string     `json:"slug"`
+	Plan                 string     `json:"plan"`
+	Profile              string     `json:"profile"`
+	ProfileVersion       int        `json:"profile_version"`
+	EC2InstanceID        string     `json:"ec2_instance_id"`
+	EC2PublicIP          string     `json:"ec2_public_ip"`
+	EC2PrivateIP         string     `json:"ec2_private_ip"`
+	EC2IPv6              string     `json:"ec2_ipv6,omitempty"`
+	EIPAllocationID      string     `json:"eip_allocation_id,omitempty"` // EC2PublicIP is an Elastic IP when set
+	SSHPrivateKey        string     `json:"-"`
+	SSHKeyUpdatedAt      *time.Time `json:"ssh_key_updated_at,omitempty"`
+	SSHCAFingerprint     string     `json:"ssh_ca_fingerprint,omitempty"`    // CA key the instance trusts, set once pushed
+	MetricsAgentVersion  string     `json:"metrics_agent_version,omitempty"` // metrics-agent.sh last run on the instance
+	Region               string     `json:"region"`                          // empty on rows from before regions were recorded
+	ReplacementID        string     `json:"-"`                               // EC2 instance a restore is launching, until it takes over or is abandoned
+	PriorStatus          Status     `json:"-"`                               // status to return to if a restore is abandoned
+	Status               Status     `json:"status"`
+	DeletionScheduledAt  *time.Time `json:"deletion_scheduled_at,omitempty"`
+	DeletionReminderDays int        `json:"-"` // smallest T-minus reminder already sent; 0 if none
+	HealthFailures       int        `json:"health_failures"`
+	CreatedAt            time.Time  `json:"created_at"`
 }
 
 type Store struct {
@@ -33,6 +61,26 @@ func NewStore(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
+// migrations add columns introduced after the instances table was created.
+var migrations = []string{
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMPTZ`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS deletion_reminder_days INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS plan TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS eip_allocation_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS ec2_private_ip TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS ec2_ipv6 TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS profile TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS profile_version INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS health_failures INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS ssh_key_updated_at TIMESTAMPTZ`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS ssh_ca_fingerprint TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS metrics_agent_version TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS replacement_ec2_instance_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instances ADD COLUMN IF NOT EXISTS prior_status TEXT NOT NULL DEFAULT ''`,
+}
+
 func (s *Store) Migrate() error {
 	_, err := s.db.Exec(`
 		CREATE TABLE IF NOT EXISTS instances (
@@ -46,29 +94,46 @@ func (s *Store) Migrate() error {
 			created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
 		)
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := s.db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *Store) Create(inst *Instance) error {
 	return s.db.QueryRow(`
-		INSERT INTO instances (stripe_customer_id, slug, status)
-		VALUES ($1, $2, $3)
+		INSERT INTO instances (stripe_customer_id, email, slug, plan, region, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at`,
-		inst.StripeCustomerID, inst.Slug, inst.Status,
+		inst.StripeCustomerID, inst.Email, inst.Slug, inst.Plan, inst.Region, inst.Status,
 	).Scan(&inst.ID, &inst.CreatedAt)
 }
 
-func (s *Store) GetByStripeID(stripeCustomerID string) (*Instance, error) {
+const columns = `
+	id, stripe_customer_id, email, slug, plan, profile, profile_version, ec2_instance_id, ec2_public_ip,
+	ec2_private_ip, ec2_ipv6, eip_allocation_id, ssh_private_key, status, deletion_scheduled_at, deletion_reminder_days,
+	health_failures, ssh_key_updated_at, ssh_ca_fingerprint, metrics_agent_version, region,
+	replacement_ec2_instance_id, prior_status, created_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Instance, error) {
 	inst := &Instance{}
-	err := s.db.QueryRow(`
-		SELECT id, stripe_customer_id, slug, ec2_instance_id, ec2_public_ip,
-		       ssh_private_key, status, created_at
-		FROM instances WHERE stripe_customer_id = $1`,
-		stripeCustomerID,
-	).Scan(
-		&inst.ID, &inst.StripeCustomerID, &inst.Slug,
-		&inst.EC2InstanceID, &inst.EC2PublicIP,
-		&inst.SSHPrivateKey, &inst.Status, &inst.CreatedAt,
+	err := row.Scan(
+		&inst.ID, &inst.StripeCustomerID, &inst.Email, &inst.Slug, &inst.Plan,
+		&inst.Profile, &inst.ProfileVersion,
+		&inst.EC2InstanceID, &inst.EC2PublicIP, &inst.EC2PrivateIP, &inst.EC2IPv6,
+		&inst.EIPAllocationID, &inst.SSHPrivateKey, &inst.Status,
+		&inst.DeletionScheduledAt, &inst.DeletionReminderDays,
+		&inst.HealthFailures, &inst.SSHKeyUpdatedAt, &inst.SSHCAFingerprint, &inst.MetricsAgentVersion, &inst.Region,
+		&inst.ReplacementID, &inst.PriorStatus, &inst.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -76,26 +141,38 @@ func (s *Store) GetByStripeID(stripeCustomerID string) (*Instance, error) {
 	return inst, err
 }
 
-func (s *Store) GetBySlug(slug string) (*Instance, error) {
-	inst := &Instance{}
-	err := s.db.QueryRow(`
-		SELECT id, stripe_customer_id, slug, ec2_instance_id, ec2_public_ip,
-		       ssh_private_key, status, created_at
-		FROM instances WHERE slug = $1`,
-		slug,
-	).Scan(
-		&inst.ID, &inst.StripeCustomerID, &inst.Slug,
-		&inst.EC2InstanceID, &inst.EC2PublicIP,
-		&inst.SSHPrivateKey, &inst.Status, &inst.CreatedAt,
-	)
-	if err == sql.ErrNoRows {
-		return nil, nil
+func (s *Store) query(where string, args ...any) ([]*Instance, error) {
+	rows, err := s.db.Query(`SELECT `+columns+` FROM instances WHERE `+where, args...)
+	if err != nil {
+		return nil, err
 	}
-	return inst, err
+	defer rows.Close()
+
+	var insts []*Instance
+	for rows.Next() {
+		inst, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		insts = append(insts, inst)
+	}
+	return insts, rows.Err()
+}
+
+func (s *Store) Get(id int64) (*Instance, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM instances WHERE id = $1`, id))
+}
+
+func (s *Store) GetByStripeID(stripeCustomerID string) (*Instance, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM instances WHERE stripe_customer_id = $1`, stripeCustomerID))
+}
+
+func (s *Store) GetBySlug(slug string) (*Instance, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM instances WHERE slug = $1`, slug))
 }
 
 func (s *Store) UpdateSSHKey(id int64, privateKey string) error {
-	_, err := s.db.Exec(`UPDATE instances SET ssh_private_key = $1 WHERE id = $2`, privateKey, id)
+	_, err := s.db.Exec(`UPDATE instances SET ssh_private_key = $1, ssh_key_updated_at = NOW() WHERE id = $2`, privateKey, id)
 	return err
 }
 
@@ -107,7 +184,170 @@ func (s *Store) UpdateEC2(id int64, instanceID, publicIP string) error {
 	return err
 }
 
+// UpdateRegion records the region an instance's EC2 instance runs in, e.g.
+// after it was migrated to another.
+func (s *Store) UpdateRegion(id int64, region string) error {
+	_, err := s.db.Exec(`UPDATE instances SET region = $1 WHERE id = $2`, region, id)
+	return err
+}
+
+// UpdateReplacement records the EC2 instance a restore is launching for an
+// instance, and the status to return to should the restore be abandoned.
+// Empty values clear both once the restore is over.
+func (s *Store) UpdateReplacement(id int64, ec2InstanceID string, prior Status) error {
+	_, err := s.db.Exec(
+		`UPDATE instances SET replacement_ec2_instance_id = $1, prior_status = $2 WHERE id = $3`,
+		ec2InstanceID, prior, id,
+	)
+	return err
+}
+
+// UpdateAddresses records the addresses AWS assigned to a running instance.
+func (s *Store) UpdateAddresses(id int64, publicIP, privateIP, ipv6 string) error {
+	_, err := s.db.Exec(
+		`UPDATE instances SET ec2_public_ip = $1, ec2_private_ip = $2, ec2_ipv6 = $3 WHERE id = $4`,
+		publicIP, privateIP, ipv6, id,
+	)
+	return err
+}
+
+// UpdateElasticIP records an instance's Elastic IP, which is also its public IP.
+func (s *Store) UpdateElasticIP(id int64, allocationID, publicIP string) error {
+	_, err := s.db.Exec(
+		`UPDATE instances SET eip_allocation_id = $1, ec2_public_ip = $2 WHERE id = $3`,
+		allocationID, publicIP, id,
+	)
+	return err
+}
+
+func (s *Store) UpdatePlan(id int64, plan string) error {
+	_, err := s.db.Exec(`UPDATE instances SET plan = $1 WHERE id = $2`, plan, id)
+	return err
+}
+
+// UpdateProfile records the provisioning profile version an instance runs.
+func (s *Store) UpdateProfile(id int64, profile string, version int) error {
+	_, err := s.db.Exec(
+		`UPDATE instances SET profile = $1, profile_version = $2 WHERE id = $3`,
+		profile, version, id,
+	)
+	return err
+}
+
 func (s *Store) UpdateStatus(id int64, status Status) error {
 	_, err := s.db.Exec(`UPDATE instances SET status = $1 WHERE id = $2`, status, id)
 	return err
 }
+
+// TransitionStatus moves an instance to status `to` only if it is currently
+// in one of `from`, reporting whether the transition happened. It guards
+// lifecycle operations against running twice concurrently.
+func (s *Store) TransitionStatus(id int64, from []Status, to Status) (bool, error) {
+	names := make([]string, len(from))
+	for i, f := range from {
+		names[i] = string(f)
+	}
+	res, err := s.db.Exec(
+		`UPDATE instances SET status = $1 WHERE id = $2 AND status = ANY($3)`,
+		to, id, pq.Array(names),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ── Scheduled deletion ────────────────────────────────────────────────────────
+
+// ScheduleDeletion records when a cancelled instance will be deprovisioned.
+func (s *Store) ScheduleDeletion(id int64, at time.Time) error {
+	_, err := s.db.Exec(
+		`UPDATE instances SET deletion_scheduled_at = $1, deletion_reminder_days = 0 WHERE id = $2`,
+		at, id,
+	)
+	return err
+}
+
+// CancelDeletion clears a pending deletion when a customer reactivates.
+func (s *Store) CancelDeletion(id int64) error {
+	_, err := s.db.Exec(
+		`UPDATE instances SET deletion_scheduled_at = NULL, deletion_reminder_days = 0 WHERE id = $1`,
+		id,
+	)
+	return err
+}
+
+// UpdateDeletionReminder records the T-minus reminder most recently sent.
+func (s *Store) UpdateDeletionReminder(id int64, days int) error {
+	_, err := s.db.Exec(`UPDATE instances SET deletion_reminder_days = $1 WHERE id = $2`, days, id)
+	return err
+}
+
+// UpdateHealthFailures records the number of consecutive failed probes.
+func (s *Store) UpdateHealthFailures(id int64, failures int) error {
+	_, err := s.db.Exec(`UPDATE instances SET health_failures = $1 WHERE id = $2`, failures, id)
+	return err
+}
+
+// IncrementHealthFailures counts one more consecutive failed probe and
+// returns the new count. Incrementing in SQL keeps a concurrent reset, e.g.
+// by a host replacement, from being overwritten with a stale count.
+func (s *Store) IncrementHealthFailures(id int64) (int, error) {
+	var failures int
+	err := s.db.QueryRow(
+		`UPDATE instances SET health_failures = health_failures + 1 WHERE id = $1 RETURNING health_failures`,
+		id,
+	).Scan(&failures)
+	return failures, err
+}
+
+// ListByStatus returns every instance in any of statuses, oldest first.
+func (s *Store) ListByStatus(statuses ...Status) ([]*Instance, error) {
+	names := make([]string, len(statuses))
+	for i, st := range statuses {
+		names[i] = string(st)
+	}
+	return s.query(`status = ANY($1) ORDER BY created_at`, pq.Array(names))
+}
+
+// ListSSHKeyDue returns running instances whose SSH key was last set before
+// the cutoff, oldest first.
+func (s *Store) ListSSHKeyDue(before time.Time) ([]*Instance, error) {
+	return s.query(`status = ANY($1) AND COALESCE(ssh_key_updated_at, created_at) < $2
+		ORDER BY COALESCE(ssh_key_updated_at, created_at)`,
+		pq.Array([]string{string(StatusActive), string(StatusDegraded)}), before)
+}
+
+// UpdateSSHCA records the CA key an instance has been made to trust.
+func (s *Store) UpdateSSHCA(id int64, fingerprint string) error {
+	_, err := s.db.Exec(`UPDATE instances SET ssh_ca_fingerprint = $1 WHERE id = $2`, fingerprint, id)
+	return err
+}
+
+// ListSSHCAStale returns running instances that don't yet trust the CA key
+// with the given fingerprint.
+func (s *Store) ListSSHCAStale(fingerprint string) ([]*Instance, error) {
+	return s.query(`status = ANY($1) AND ssh_ca_fingerprint <> $2 ORDER BY id`,
+		pq.Array([]string{string(StatusActive), string(StatusDegraded)}), fingerprint)
+}
+
+// UpdateMetricsAgent records the metrics-agent.sh version last run on an
+// instance.
+func (s *Store) UpdateMetricsAgent(id int64, version string) error {
+	_, err := s.db.Exec(`UPDATE instances SET metrics_agent_version = $1 WHERE id = $2`, version, id)
+	return err
+}
+
+// ListMetricsAgentStale returns running instances that haven't run the
+// given metrics-agent.sh version.
+func (s *Store) ListMetricsAgentStale(version string) ([]*Instance, error) {
+	return s.query(`status = ANY($1) AND metrics_agent_version <> $2 ORDER BY id`,
+		pq.Array([]string{string(StatusActive), string(StatusDegraded)}), version)
+}
+
+// ListPendingDeletion returns every instance awaiting scheduled deletion.
+func (s *Store) ListPendingDeletion() ([]*Instance, error) {
+	return s.query(`status = $1 AND deletion_scheduled_at IS NOT NULL ORDER BY deletion_scheduled_at`,
+		StatusPendingDeletion)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// insightsWindow is as far back as Metrics Insights queries reach.
+const insightsWindow = 3 * time.Hour
+
+// queries are Metrics Insights expressions per metric. CPU comes from EC2
+// itself; memory and disk from the CloudWatch agent metrics-agent.sh installs.
+var queries = map[string]string{
+	CPU:    `SELECT AVG(CPUUtilization) FROM SCHEMA("AWS/EC2", InstanceId) WHERE InstanceId = '%s'`,
+	Memory: `SELECT AVG(mem_used_percent) FROM CWAgent WHERE InstanceId = '%s'`,
+	Disk:   `SELECT MAX(disk_used_percent) FROM CWAgent WHERE InstanceId = '%s' AND path = '/'`,
+}
+
+// CloudWatch reads instance metrics from CloudWatch.
+type CloudWatch struct {
+	cw     *cloudwatch.Client
+	period time.Duration
+}
+
+func NewCloudWatch(awsCfg aws.Config, period time.Duration) *CloudWatch {
+	return &CloudWatch{cw: cloudwatch.NewFromConfig(awsCfg), period: period}
+}
+
+func (c *CloudWatch) Collect(ctx context.Context, region, ec2InstanceID string, since time.Time) ([]Sample, error) {
+	now := time.Now()
+	if since.Before(now.Add(-insightsWindow)) {
+		since = now.Add(-insightsWindow)
+	}
+
+	input := &cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(since),
+		EndTime:   aws.Time(now),
+	}
+	for _, name := range Names {
+		input.MetricDataQueries = append(input.MetricDataQueries, cwtypes.MetricDataQuery{
+			Id:         aws.String(name),
+			Expression: aws.String(fmt.Sprintf(queries[name], ec2InstanceID)),
+			Period:     aws.Int32(int32(c.period.Seconds())),
+		})
+	}
+
+	var samples []Sample
+	cw := c.cw
+	if region != "" {
+		// The agent publishes to the region the instance runs in
+		cw = cloudwatch.New(c.cw.Options(), func(o *cloudwatch.Options) { o.Region = region })
+	}
+	pages := cloudwatch.NewGetMetricDataPaginator(cw, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get metric data: %w", err)
+		}
+		for _, r := range page.MetricDataResults {
+			for i, ts := range r.Timestamps {
+				if !ts.After(since) {
+					continue
+				}
+				samples = append(samples, Sample{Metric: aws.ToString(r.Id), Value: r.Values[i], CollectedAt: ts})
+			}
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// Fake generates plausible, deterministic datapoints for local development
+// without CloudWatch. Each instance gets a steady disk level and a daily
+// CPU and memory cycle, unless a metric has been pinned with Set.
+type Fake struct {
+	Period time.Duration
+
+	mu    sync.Mutex
+	fixed map[string]float64
+}
+
+// Set pins a metric to value on every instance until cleared, e.g. to push
+// disk usage past the alert threshold.
+func (f *Fake) Set(metric string, value float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fixed == nil {
+		f.fixed = map[string]float64{}
+	}
+	f.fixed[metric] = value
+}
+
+// Clear returns a metric to its generated values.
+func (f *Fake) Clear(metric string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.fixed, metric)
+}
+
+func (f *Fake) Collect(_ context.Context, _, ec2InstanceID string, since time.Time) ([]Sample, error) {
+	period := f.Period
+	if period <= 0 {
+		period = 5 * time.Minute
+	}
+	h := fnv.New32a()
+	h.Write([]byte(ec2InstanceID))
+	seed := float64(h.Sum32()%1000) / 1000
+
+	var samples []Sample
+	start := since.Truncate(period).Add(period)
+	if start.Before(time.Now().Add(-insightsWindow)) {
+		start = time.Now().Add(-insightsWindow).Truncate(period)
+	}
+	for ts := start; !ts.After(time.Now()); ts = ts.Add(period) {
+		day := 2 * math.Pi * float64(ts.Unix()%86400) / 86400
+		samples = append(samples,
+			f.sample(CPU, 10+20*seed+10*math.Sin(day), ts),
+			f.sample(Memory, 40+20*seed+5*math.Sin(day), ts),
+			f.sample(Disk, 30+60*seed, ts),
+		)
+	}
+	return samples, nil
+}
+
+func (f *Fake) sample(metric string, value float64, ts time.Time) Sample {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.fixed[metric]; ok {
+		value = v
+	}
+	return Sample{Metric: metric, Value: value, CollectedAt: ts}
+}
@@ -0,0 +1,209 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Metrics are utilisation percentages, 0–100.
+const (
+	CPU    = "cpu"
+	Memory = "memory"
+	Disk   = "disk" // of the root volume
+)
+
+var Names = []string{CPU, Memory, Disk}
+
+// Sample is one datapoint of one metric on one instance.
+type Sample struct {
+	InstanceID  int64     `json:"-"`
+	Metric      string    `json:"metric"`
+	Value       float64   `json:"value"`
+	CollectedAt time.Time `json:"collected_at"`
+}
+
+// Source fetches an EC2 instance's datapoints since a time from the region
+// it runs in, empty for the source's own. InstanceID is left for the caller
+// to fill in.
+type Source interface {
+	Collect(ctx context.Context, region, ec2InstanceID string, since time.Time) ([]Sample, error)
+}
+
+// Alert is raised when a metric crosses its threshold and resolved once it
+// falls back below.
+type Alert struct {
+	ID         int64      `json:"id"`
+	InstanceID int64      `json:"instance_id"`
+	Metric     string     `json:"metric"`
+	Threshold  float64    `json:"threshold"`
+	Value      float64    `json:"value"`
+	RaisedAt   time.Time  `json:"raised_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS metric_samples (
+			instance_id   BIGINT NOT NULL,
+			metric        TEXT NOT NULL,
+			value         DOUBLE PRECISION NOT NULL,
+			collected_at  TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (instance_id, metric, collected_at)
+		);
+		CREATE TABLE IF NOT EXISTS metric_alerts (
+			id           BIGSERIAL PRIMARY KEY,
+			instance_id  BIGINT NOT NULL,
+			metric       TEXT NOT NULL,
+			threshold    DOUBLE PRECISION NOT NULL,
+			value        DOUBLE PRECISION NOT NULL,
+			raised_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			resolved_at  TIMESTAMPTZ
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS metric_alerts_open_idx ON metric_alerts (instance_id, metric) WHERE resolved_at IS NULL
+	`)
+	return err
+}
+
+// Record stores samples, ignoring datapoints already collected.
+func (s *Store) Record(samples []Sample) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO metric_samples (instance_id, metric, value, collected_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, sm := range samples {
+		if _, err := stmt.Exec(sm.InstanceID, sm.Metric, sm.Value, sm.CollectedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LastCollected returns the newest datapoint stored for an instance, or the
+// zero time if there is none.
+func (s *Store) LastCollected(instanceID int64) (time.Time, error) {
+	var last sql.NullTime
+	err := s.db.QueryRow(`SELECT MAX(collected_at) FROM metric_samples WHERE instance_id = $1`, instanceID).Scan(&last)
+	return last.Time, err
+}
+
+// Latest returns the newest sample of each metric.
+func (s *Store) Latest(instanceID int64) ([]Sample, error) {
+	return s.query(`
+		SELECT DISTINCT ON (metric) instance_id, metric, value, collected_at
+		FROM metric_samples WHERE instance_id = $1
+		ORDER BY metric, collected_at DESC`,
+		instanceID,
+	)
+}
+
+// Since returns an instance's samples after a time, oldest first. An empty
+// metric returns all of them.
+func (s *Store) Since(instanceID int64, metric string, since time.Time) ([]Sample, error) {
+	return s.query(`
+		SELECT instance_id, metric, value, collected_at
+		FROM metric_samples
+		WHERE instance_id = $1 AND ($2 = '' OR metric = $2) AND collected_at > $3
+		ORDER BY collected_at, metric`,
+		instanceID, metric, since,
+	)
+}
+
+func (s *Store) query(q string, args ...any) ([]Sample, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []Sample
+	for rows.Next() {
+		var sm Sample
+		if err := rows.Scan(&sm.InstanceID, &sm.Metric, &sm.Value, &sm.CollectedAt); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sm)
+	}
+	return samples, rows.Err()
+}
+
+// Prune deletes samples collected before a time.
+func (s *Store) Prune(before time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM metric_samples WHERE collected_at < $1`, before)
+	return err
+}
+
+// ── Alerts ────────────────────────────────────────────────────────────────────
+
+// Raise opens an alert, reporting false if one is already open for the
+// instance and metric.
+func (s *Store) Raise(a *Alert) (bool, error) {
+	err := s.db.QueryRow(`
+		INSERT INTO metric_alerts (instance_id, metric, threshold, value)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (instance_id, metric) WHERE resolved_at IS NULL DO NOTHING
+		RETURNING id, raised_at`,
+		a.InstanceID, a.Metric, a.Threshold, a.Value,
+	).Scan(&a.ID, &a.RaisedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Resolve closes the open alert for the instance and metric, if any.
+func (s *Store) Resolve(instanceID int64, metric string) (bool, error) {
+	res, err := s.db.Exec(`
+		UPDATE metric_alerts SET resolved_at = NOW()
+		WHERE instance_id = $1 AND metric = $2 AND resolved_at IS NULL`,
+		instanceID, metric,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// OpenAlerts returns an instance's unresolved alerts.
+func (s *Store) OpenAlerts(instanceID int64) ([]*Alert, error) {
+	rows, err := s.db.Query(`
+		SELECT id, instance_id, metric, threshold, value, raised_at, resolved_at
+		FROM metric_alerts WHERE instance_id = $1 AND resolved_at IS NULL
+		ORDER BY raised_at`,
+		instanceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []*Alert
+	for rows.Next() {
+		a := &Alert{}
+		if err := rows.Scan(&a.ID, &a.InstanceID, &a.Metric, &a.Threshold, &a.Value, &a.RaisedAt, &a.ResolvedAt); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
@@ -0,0 +1,174 @@
+package migration
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusRunning    Status = "running"
+	StatusSucceeded  Status = "succeeded"
+	StatusRolledBack Status = "rolled_back" // failed; the hub is back on its source host
+	StatusFailed     Status = "failed"      // failed and could not be rolled back cleanly
+)
+
+// Steps a migration goes through, in order.
+const (
+	StepStopping     = "stopping"     // quiescing services on the source
+	StepSnapshotting = "snapshotting" // snapshotting the source's root volume
+	StepCopying      = "copying"      // copying the snapshot to another region
+	StepLaunching    = "launching"    // launching the destination from the snapshot
+	StepSwitching    = "switching"    // verifying, cutting over and terminating the source
+	StepDone         = "done"
+)
+
+// Migration moves a hub onto a new EC2 instance, optionally of another type,
+// in another subnet or in another region.
+type Migration struct {
+	ID            int64      `json:"id"`
+	InstanceID    int64      `json:"instance_id"`
+	Slug          string     `json:"slug"`
+	Reason        string     `json:"reason,omitempty"`
+	Source        string     `json:"source_ec2_instance_id"`
+	Destination   string     `json:"destination_ec2_instance_id,omitempty"`
+	FromType      string     `json:"from_instance_type"`
+	ToType        string     `json:"to_instance_type"`
+	FromRegion    string     `json:"from_region"`
+	ToRegion      string     `json:"to_region"`
+	SubnetID      string     `json:"subnet_id,omitempty"` // empty uses the region's configured subnet
+	EC2SnapshotID string     `json:"ec2_snapshot_id,omitempty"`
+	Status        Status     `json:"status"`
+	Step          string     `json:"step"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// migrations add columns introduced after the instance_migrations table was
+// created.
+var migrations = []string{
+	`ALTER TABLE instance_migrations ADD COLUMN IF NOT EXISTS from_region TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE instance_migrations ADD COLUMN IF NOT EXISTS to_region TEXT NOT NULL DEFAULT ''`,
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS instance_migrations (
+			id               BIGSERIAL PRIMARY KEY,
+			instance_id      BIGINT NOT NULL,
+			slug             TEXT NOT NULL,
+			reason           TEXT NOT NULL DEFAULT '',
+			source           TEXT NOT NULL,
+			destination      TEXT NOT NULL DEFAULT '',
+			from_type        TEXT NOT NULL,
+			to_type          TEXT NOT NULL,
+			subnet_id        TEXT NOT NULL DEFAULT '',
+			ec2_snapshot_id  TEXT NOT NULL DEFAULT '',
+			status           TEXT NOT NULL,
+			step             TEXT NOT NULL,
+			error            TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at      TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS instance_migrations_instance_idx ON instance_migrations (instance_id, created_at DESC)
+	`)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := s.db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Create(m *Migration) error {
+	m.Status, m.Step = StatusRunning, StepStopping
+	return s.db.QueryRow(`
+		INSERT INTO instance_migrations (instance_id, slug, reason, source, from_type, to_type, from_region, to_region, subnet_id, status, step)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at`,
+		m.InstanceID, m.Slug, m.Reason, m.Source, m.FromType, m.ToType, m.FromRegion, m.ToRegion, m.SubnetID, m.Status, m.Step,
+	).Scan(&m.ID, &m.CreatedAt)
+}
+
+// Update records a running migration's progress.
+func (s *Store) Update(m *Migration) error {
+	_, err := s.db.Exec(`
+		UPDATE instance_migrations SET step = $1, destination = $2, ec2_snapshot_id = $3
+		WHERE id = $4`,
+		m.Step, m.Destination, m.EC2SnapshotID, m.ID,
+	)
+	return err
+}
+
+func (s *Store) Finish(m *Migration) error {
+	return s.db.QueryRow(`
+		UPDATE instance_migrations SET status = $1, step = $2, destination = $3, ec2_snapshot_id = $4, error = $5, finished_at = NOW()
+		WHERE id = $6
+		RETURNING finished_at`,
+		m.Status, m.Step, m.Destination, m.EC2SnapshotID, m.Error, m.ID,
+	).Scan(&m.FinishedAt)
+}
+
+const columns = `id, instance_id, slug, reason, source, destination, from_type, to_type, from_region, to_region, subnet_id,
+	ec2_snapshot_id, status, step, error, created_at, finished_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Migration, error) {
+	m := &Migration{}
+	err := row.Scan(
+		&m.ID, &m.InstanceID, &m.Slug, &m.Reason, &m.Source, &m.Destination, &m.FromType, &m.ToType, &m.FromRegion, &m.ToRegion, &m.SubnetID,
+		&m.EC2SnapshotID, &m.Status, &m.Step, &m.Error, &m.CreatedAt, &m.FinishedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+func (s *Store) Get(id int64) (*Migration, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM instance_migrations WHERE id = $1`, id))
+}
+
+// ListByInstance returns an instance's migrations, newest first.
+func (s *Store) ListByInstance(instanceID int64) ([]*Migration, error) {
+	return s.list(`SELECT `+columns+` FROM instance_migrations WHERE instance_id = $1 ORDER BY created_at DESC`, instanceID)
+}
+
+// ListRunning returns migrations still marked running.
+func (s *Store) ListRunning() ([]*Migration, error) {
+	return s.list(`SELECT `+columns+` FROM instance_migrations WHERE status = $1 ORDER BY created_at`, StatusRunning)
+}
+
+func (s *Store) list(query string, args ...any) ([]*Migration, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ms []*Migration
+	for rows.Next() {
+		m, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
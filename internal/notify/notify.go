@@ -3,6 +3,7 @@ package notify
 import (
 	"context"
 	"fmt"
+	"time"
 
 	resend "github.com/resend/resend-go/v2"
 )
@@ -38,13 +39,59 @@ func (c *Client) SendWelcome(_ context.Context, email, slug, password string) er
 	return err
 }
 
-func (c *Client) SendDataExport(_ context.Context, email, downloadURL string) error {
+func (c *Client) SendDataExport(_ context.Context, email, downloadURL string, expiry time.Duration) error {
+	expires := humanDuration(expiry)
 	_, err := c.resend.Emails.Send(&resend.SendEmailRequest{
 		From:    c.cfg.FromEmail,
 		To:      []string{email},
 		Subject: "Your Crimata data export is ready",
-		Text:    fmt.Sprintf("Your data export is ready for download:\n\n%s\n\nThis link expires in 24 hours.\n\n— Crimata", downloadURL),
-		Html:    fmt.Sprintf(`<p>Your data export is ready: <a href="%s">Download</a></p><p style="color:#555">This link expires in 24 hours.</p><p>— Crimata</p>`, downloadURL),
+		Text:    fmt.Sprintf("Your data export is ready for download:\n\n%s\n\nThis link expires in %s.\n\n— Crimata", downloadURL, expires),
+		Html:    fmt.Sprintf(`<p>Your data export is ready: <a href="%s">Download</a></p><p style="color:#555">This link expires in %s.</p><p>— Crimata</p>`, downloadURL, expires),
+	})
+	return err
+}
+
+// humanDuration renders a link lifetime as whole hours or days.
+func humanDuration(d time.Duration) string {
+	hours := int(d.Round(time.Hour) / time.Hour)
+	switch {
+	case hours <= 1:
+		return "1 hour"
+	case hours < 48 || hours%24 != 0:
+		return fmt.Sprintf("%d hours", hours)
+	default:
+		return fmt.Sprintf("%d days", hours/24)
+	}
+}
+
+func (c *Client) SendDeletionReminder(_ context.Context, email, slug string, deleteAt time.Time) error {
+	url := fmt.Sprintf("https://%s.%s", slug, c.cfg.BaseDomain)
+	days := int(time.Until(deleteAt).Round(24*time.Hour) / (24 * time.Hour))
+	when := "tomorrow"
+	if days > 1 {
+		when = fmt.Sprintf("in %d days", days)
+	}
+	date := deleteAt.UTC().Format("January 2, 2006")
+
+	_, err := c.resend.Emails.Send(&resend.SendEmailRequest{
+		From:    c.cfg.FromEmail,
+		To:      []string{email},
+		Subject: fmt.Sprintf("Your Crimata hub will be deleted %s", when),
+		Text:    fmt.Sprintf("Your hub at %s is suspended and will be permanently deleted on %s.\n\nReactivate your subscription before then to keep it. After deletion we will email you an export of your data.\n\n— Crimata", url, date),
+		Html:    fmt.Sprintf(`<p>Your hub at <a href="%s">%s</a> is suspended and will be permanently deleted on <strong>%s</strong>.</p><p>Reactivate your subscription before then to keep it. After deletion we will email you an export of your data.</p><p>— Crimata</p>`, url, url, date),
+	})
+	return err
+}
+
+func (c *Client) SendDiskAlert(_ context.Context, email, slug string, percent float64) error {
+	url := fmt.Sprintf("https://%s.%s", slug, c.cfg.BaseDomain)
+
+	_, err := c.resend.Emails.Send(&resend.SendEmailRequest{
+		From:    c.cfg.FromEmail,
+		To:      []string{email},
+		Subject: "Your Crimata hub is running out of disk space",
+		Text:    fmt.Sprintf("Your hub at %s is using %.0f%% of its disk.\n\nWhen it fills up your apps will stop saving data. Delete files you no longer need, or upgrade to a plan with more storage.\n\n— Crimata", url, percent),
+		Html:    fmt.Sprintf(`<p>Your hub at <a href="%s">%s</a> is using <strong>%.0f%%</strong> of its disk.</p><p>When it fills up your apps will stop saving data. Delete files you no longer need, or upgrade to a plan with more storage.</p><p>— Crimata</p>`, url, url, percent),
 	})
 	return err
 }
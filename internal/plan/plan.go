@@ -0,0 +1,136 @@
+package plan
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Plan is a named hardware tier customers subscribe to through Stripe.
+type Plan struct {
+	Name          string    `json:"name"`
+	StripePriceID string    `json:"stripe_price_id"`
+	InstanceType  string    `json:"instance_type"`
+	DiskSizeGB    int32     `json:"disk_size_gb"`   // 0 keeps the AMI's default root volume
+	DiskType      string    `json:"disk_type"`      // EBS volume type, e.g. gp3; empty keeps the AMI's
+	DiskIOPS      int32     `json:"disk_iops"`      // provisioned IOPS for gp3, io1 and io2
+	DiskEncrypted bool      `json:"disk_encrypted"` // encrypt the root volume at rest
+	WarmPoolSize  int       `json:"warm_pool_size"` // pre-provisioned instances kept ready to claim
+	Features      []string  `json:"features"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// migrations add columns introduced after the plans table was created.
+var migrations = []string{
+	`ALTER TABLE plans ADD COLUMN IF NOT EXISTS warm_pool_size INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE plans ADD COLUMN IF NOT EXISTS disk_type TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE plans ADD COLUMN IF NOT EXISTS disk_iops INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE plans ADD COLUMN IF NOT EXISTS disk_encrypted BOOLEAN NOT NULL DEFAULT FALSE`,
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS plans (
+			name             TEXT PRIMARY KEY,
+			stripe_price_id  TEXT UNIQUE,
+			instance_type    TEXT NOT NULL,
+			disk_size_gb     INTEGER NOT NULL DEFAULT 0,
+			features         TEXT[] NOT NULL DEFAULT '{}',
+			created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := s.db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Seed inserts p unless a plan with the same name already exists.
+func (s *Store) Seed(p *Plan) error {
+	_, err := s.db.Exec(`
+		INSERT INTO plans (name, stripe_price_id, instance_type, disk_size_gb, disk_type, disk_iops, disk_encrypted, features, warm_pool_size)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (name) DO NOTHING`,
+		p.Name, p.StripePriceID, p.InstanceType, p.DiskSizeGB, p.DiskType, p.DiskIOPS, p.DiskEncrypted, pq.Array(p.Features), p.WarmPoolSize,
+	)
+	return err
+}
+
+// Upsert creates or replaces a plan.
+func (s *Store) Upsert(p *Plan) error {
+	return s.db.QueryRow(`
+		INSERT INTO plans (name, stripe_price_id, instance_type, disk_size_gb, disk_type, disk_iops, disk_encrypted, features, warm_pool_size)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (name) DO UPDATE SET
+			stripe_price_id = EXCLUDED.stripe_price_id,
+			instance_type   = EXCLUDED.instance_type,
+			disk_size_gb    = EXCLUDED.disk_size_gb,
+			disk_type       = EXCLUDED.disk_type,
+			disk_iops       = EXCLUDED.disk_iops,
+			disk_encrypted  = EXCLUDED.disk_encrypted,
+			features        = EXCLUDED.features,
+			warm_pool_size  = EXCLUDED.warm_pool_size
+		RETURNING created_at`,
+		p.Name, p.StripePriceID, p.InstanceType, p.DiskSizeGB, p.DiskType, p.DiskIOPS, p.DiskEncrypted, pq.Array(p.Features), p.WarmPoolSize,
+	).Scan(&p.CreatedAt)
+}
+
+const columns = `name, COALESCE(stripe_price_id, ''), instance_type, disk_size_gb, disk_type, disk_iops, disk_encrypted,
+	features, warm_pool_size, created_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Plan, error) {
+	p := &Plan{}
+	err := row.Scan(
+		&p.Name, &p.StripePriceID, &p.InstanceType,
+		&p.DiskSizeGB, &p.DiskType, &p.DiskIOPS, &p.DiskEncrypted,
+		pq.Array(&p.Features), &p.WarmPoolSize, &p.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (s *Store) Get(name string) (*Plan, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM plans WHERE name = $1`, name))
+}
+
+func (s *Store) GetByStripePrice(priceID string) (*Plan, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM plans WHERE stripe_price_id = $1`, priceID))
+}
+
+func (s *Store) List() ([]*Plan, error) {
+	rows, err := s.db.Query(`SELECT ` + columns + ` FROM plans ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []*Plan
+	for rows.Next() {
+		p, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
@@ -0,0 +1,181 @@
+package pool
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusWarming Status = "warming" // launched, base install in progress
+	StatusReady   Status = "ready"   // running and waiting to be claimed
+)
+
+// Instance is a pre-provisioned EC2 instance that belongs to no customer yet.
+// Claiming one removes it from the pool.
+type Instance struct {
+	ID            int64     `json:"id"`
+	Plan          string    `json:"plan"`
+	InstanceType  string    `json:"instance_type"`
+	ImageID       string    `json:"image_id"` // the AMI it launched from
+	DiskSizeGB    int32     `json:"disk_size_gb"`
+	DiskType      string    `json:"disk_type"`
+	DiskIOPS      int32     `json:"disk_iops"`
+	DiskEncrypted bool      `json:"disk_encrypted"`
+	EC2InstanceID string    `json:"ec2_instance_id"`
+	SSHPrivateKey string    `json:"-"`
+	Status        Status    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// migrations add columns introduced after the pool_instances table was
+// created.
+var migrations = []string{
+	`ALTER TABLE pool_instances ADD COLUMN IF NOT EXISTS image_id TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE pool_instances ADD COLUMN IF NOT EXISTS disk_size_gb INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE pool_instances ADD COLUMN IF NOT EXISTS disk_type TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE pool_instances ADD COLUMN IF NOT EXISTS disk_iops INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE pool_instances ADD COLUMN IF NOT EXISTS disk_encrypted BOOLEAN NOT NULL DEFAULT FALSE`,
+}
+
+// Matches reports whether inst has the instance type and root volume spec
+// asks for.
+func (inst *Instance) Matches(spec *Instance) bool {
+	return inst.InstanceType == spec.InstanceType &&
+		inst.DiskSizeGB == spec.DiskSizeGB &&
+		inst.DiskType == spec.DiskType &&
+		inst.DiskIOPS == spec.DiskIOPS &&
+		inst.DiskEncrypted == spec.DiskEncrypted
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS pool_instances (
+			id               BIGSERIAL PRIMARY KEY,
+			plan             TEXT NOT NULL,
+			instance_type    TEXT NOT NULL,
+			ec2_instance_id  TEXT NOT NULL DEFAULT '',
+			ssh_private_key  TEXT NOT NULL DEFAULT '',
+			status           TEXT NOT NULL DEFAULT 'warming',
+			created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := s.db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create reserves a warming slot so concurrent replenish runs count it
+// before the instance exists.
+func (s *Store) Create(inst *Instance) error {
+	return s.db.QueryRow(`
+		INSERT INTO pool_instances (plan, instance_type, disk_size_gb, disk_type, disk_iops, disk_encrypted, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`,
+		inst.Plan, inst.InstanceType, inst.DiskSizeGB, inst.DiskType, inst.DiskIOPS, inst.DiskEncrypted, StatusWarming,
+	).Scan(&inst.ID, &inst.CreatedAt)
+}
+
+// UpdateEC2 records the instance launched for a warming slot, reporting
+// false if the slot was retired in the meantime.
+func (s *Store) UpdateEC2(id int64, ec2InstanceID, sshPrivateKey, imageID string) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE pool_instances SET ec2_instance_id = $1, ssh_private_key = $2, image_id = $3 WHERE id = $4`,
+		ec2InstanceID, sshPrivateKey, imageID, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *Store) MarkReady(id int64) error {
+	_, err := s.db.Exec(`UPDATE pool_instances SET status = $1 WHERE id = $2`, StatusReady, id)
+	return err
+}
+
+// Remove deletes an instance from the pool, reporting false if it was
+// already gone, e.g. claimed by a signup in the meantime.
+func (s *Store) Remove(id int64) (bool, error) {
+	res, err := s.db.Exec(`DELETE FROM pool_instances WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Claim atomically removes and returns the oldest ready instance with spec's
+// plan, instance type and root volume, or nil if the pool has none.
+func (s *Store) Claim(spec *Instance) (*Instance, error) {
+	inst, err := scan(s.db.QueryRow(`
+		DELETE FROM pool_instances
+		WHERE id = (
+			SELECT id FROM pool_instances
+			WHERE plan = $1 AND instance_type = $2 AND status = $3
+				AND disk_size_gb = $4 AND disk_type = $5 AND disk_iops = $6 AND disk_encrypted = $7
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+columns,
+		spec.Plan, spec.InstanceType, StatusReady,
+		spec.DiskSizeGB, spec.DiskType, spec.DiskIOPS, spec.DiskEncrypted,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return inst, err
+}
+
+// ListByPlan returns every warming and ready instance for a plan, oldest
+// first.
+func (s *Store) ListByPlan(plan string) ([]*Instance, error) {
+	rows, err := s.db.Query(`SELECT `+columns+` FROM pool_instances WHERE plan = $1 ORDER BY created_at`, plan)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var insts []*Instance
+	for rows.Next() {
+		inst, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		insts = append(insts, inst)
+	}
+	return insts, rows.Err()
+}
+
+const columns = `id, plan, instance_type, image_id, disk_size_gb, disk_type, disk_iops, disk_encrypted,
+	ec2_instance_id, ssh_private_key, status, created_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Instance, error) {
+	inst := &Instance{}
+	err := row.Scan(
+		&inst.ID, &inst.Plan, &inst.InstanceType, &inst.ImageID, &inst.DiskSizeGB, &inst.DiskType, &inst.DiskIOPS, &inst.DiskEncrypted,
+		&inst.EC2InstanceID, &inst.SSHPrivateKey, &inst.Status, &inst.CreatedAt,
+	)
+	return inst, err
+}
@@ -0,0 +1,204 @@
+package profile
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"hash/fnv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultScript is the provisioning script shipped with this build. It seeds
+// version 1 of the default profile.
+//
+//go:embed provision.sh
+var DefaultScript string
+
+// DefaultExport lists the directories under /opt/crimata exported when a
+// profile doesn't name any.
+var DefaultExport = []string{"data"}
+
+// Profile is one version of a named provisioning profile: the per-customer
+// script, the parameters it runs with, and what a data export contains.
+// New instances pick a version by rollout percentage.
+type Profile struct {
+	Name           string            `json:"name"`
+	Version        int               `json:"version"`
+	Script         string            `json:"script"`
+	Params         map[string]string `json:"params"`          // values are redacted in JSON output
+	Export         []string          `json:"export"`          // directories under /opt/crimata
+	RolloutPercent int               `json:"rollout_percent"` // share of new instances, 0-100
+	KeepsPassword  bool              `json:"keeps_password"`  // the script keeps the user's password when passed an empty one
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// redacted replaces parameter values in API responses.
+const redacted = "********"
+
+// MarshalJSON hides parameter values, which hold secrets such as API keys;
+// only their names are shown.
+func (p Profile) MarshalJSON() ([]byte, error) {
+	type plain Profile
+	out := plain(p)
+	if p.Params != nil {
+		out.Params = make(map[string]string, len(p.Params))
+		for k := range p.Params {
+			out.Params[k] = redacted
+		}
+	}
+	return json.Marshal(out)
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// migrations add columns introduced after the profiles table was created.
+// Existing versions predate keeps_password and may reset the password.
+var migrations = []string{
+	`ALTER TABLE profiles ADD COLUMN IF NOT EXISTS keeps_password BOOLEAN NOT NULL DEFAULT FALSE`,
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS profiles (
+			name             TEXT NOT NULL,
+			version          INTEGER NOT NULL,
+			script           TEXT NOT NULL,
+			params           JSONB NOT NULL DEFAULT '{}',
+			export           TEXT[] NOT NULL DEFAULT '{}',
+			rollout_percent  INTEGER NOT NULL DEFAULT 0,
+			created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (name, version)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := s.db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Seed creates version 1 of a profile, fully rolled out, unless the profile
+// already has versions. script must be DefaultScript or equally keep the
+// password when passed an empty one.
+func (s *Store) Seed(name, script string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO profiles (name, version, script, export, rollout_percent, keeps_password)
+		VALUES ($1, 1, $2, $3, 100, TRUE)
+		ON CONFLICT (name, version) DO NOTHING`,
+		name, script, pq.Array(DefaultExport),
+	)
+	return err
+}
+
+// Create stores p as the next version of its profile and sets p.Version.
+func (s *Store) Create(p *Profile) error {
+	params, err := json.Marshal(p.Params)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRow(`
+		INSERT INTO profiles (name, version, script, params, export, rollout_percent, keeps_password)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6
+		FROM profiles WHERE name = $1
+		RETURNING version, created_at`,
+		p.Name, p.Script, params, pq.Array(p.Export), p.RolloutPercent, p.KeepsPassword,
+	).Scan(&p.Version, &p.CreatedAt)
+}
+
+// SetRollout changes the share of new instances a version is used for.
+func (s *Store) SetRollout(name string, version, percent int) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE profiles SET rollout_percent = $1 WHERE name = $2 AND version = $3`,
+		percent, name, version,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+const columns = `name, version, script, params, export, rollout_percent, keeps_password, created_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Profile, error) {
+	p := &Profile{}
+	var params []byte
+	err := row.Scan(&p.Name, &p.Version, &p.Script, &params, pq.Array(&p.Export), &p.RolloutPercent, &p.KeepsPassword, &p.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params, &p.Params); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *Store) Get(name string, version int) (*Profile, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM profiles WHERE name = $1 AND version = $2`, name, version))
+}
+
+// List returns every version of a profile, newest first. An empty name lists
+// all profiles.
+func (s *Store) List(name string) ([]*Profile, error) {
+	rows, err := s.db.Query(`
+		SELECT `+columns+` FROM profiles
+		WHERE $1 = '' OR name = $1
+		ORDER BY name, version DESC`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []*Profile
+	for rows.Next() {
+		p, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+// Pick chooses the version of a profile a new instance runs. Each slug falls
+// in a stable bucket from 0 to 99; the newest version whose rollout covers
+// that bucket wins, so raising a version's percentage only ever moves
+// instances forward. Returns nil if no version covers the bucket.
+func (s *Store) Pick(name, slug string) (*Profile, error) {
+	versions, err := s.List(name)
+	if err != nil {
+		return nil, err
+	}
+	b := bucket(slug)
+	for _, p := range versions {
+		if b < p.RolloutPercent {
+			return p, nil
+		}
+	}
+	return nil, nil
+}
+
+func bucket(slug string) int {
+	h := fnv.New32a()
+	h.Write([]byte(slug))
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,198 @@
+package remediation
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrBusy        = errors.New("another action is running on this instance")
+	ErrRateLimited = errors.New("action rate limit reached")
+)
+
+// Action is a declarative remediation step. Limit caps how often it may run
+// on one instance within Window, so a crash loop can't become a reboot loop.
+type Action struct {
+	Name        string
+	Description string
+	Limit       int
+	Window      time.Duration
+}
+
+const (
+	RestartService = "restart-service"
+	RestartNginx   = "restart-nginx"
+	Reboot         = "reboot"
+	Reprovision    = "reprovision"
+)
+
+var Actions = map[string]Action{
+	RestartService: {RestartService, "Restart one Crimata systemd unit", 6, time.Hour},
+	RestartNginx:   {RestartNginx, "Validate the nginx config and restart nginx", 6, time.Hour},
+	Reboot:         {Reboot, "Reboot the EC2 instance", 2, 6 * time.Hour},
+	Reprovision:    {Reprovision, "Re-run the instance's provisioning profile, keeping the customer's password", 1, 24 * time.Hour},
+}
+
+// Units are the systemd units restart-service may touch.
+var Units = []string{"crimata-auth", "crimata-dock", "crimata-contacts", "crimata-agent", "postgresql"}
+
+// PathUnits maps the hub endpoints the health prober checks to the unit
+// serving them. The root is nginx itself.
+var PathUnits = map[string]string{
+	"/auth/":          "crimata-auth",
+	"/dock/":          "crimata-dock",
+	"/apps/contacts/": "crimata-contacts",
+}
+
+func ValidUnit(unit string) bool {
+	for _, u := range Units {
+		if u == unit {
+			return true
+		}
+	}
+	return false
+}
+
+type Status string
+
+const (
+	StatusRunning     Status = "running"
+	StatusSucceeded   Status = "succeeded"
+	StatusFailed      Status = "failed"
+	StatusRateLimited Status = "rate_limited" // refused by the action's limit; never ran
+)
+
+// Run is one execution of an action, kept as an audit log.
+type Run struct {
+	ID         int64      `json:"id"`
+	InstanceID int64      `json:"instance_id"`
+	Action     string     `json:"action"`
+	Unit       string     `json:"unit,omitempty"`
+	Trigger    string     `json:"trigger"` // "manual" or "health"
+	Status     Status     `json:"status"`
+	Output     string     `json:"output,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS remediations (
+			id           BIGSERIAL PRIMARY KEY,
+			instance_id  BIGINT NOT NULL,
+			action       TEXT NOT NULL,
+			unit         TEXT NOT NULL DEFAULT '',
+			trigger      TEXT NOT NULL,
+			status       TEXT NOT NULL,
+			output       TEXT NOT NULL DEFAULT '',
+			error        TEXT NOT NULL DEFAULT '',
+			started_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at  TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS remediations_instance_idx ON remediations (instance_id, started_at DESC)
+	`)
+	return err
+}
+
+// Start records a run as running. It fails with ErrBusy, recording nothing,
+// while the instance has another run in progress, and with ErrRateLimited,
+// recording the refused run, once the action has hit its limit.
+func (s *Store) Start(r *Run) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialise starts per instance so the checks below can't race
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, r.InstanceID); err != nil {
+		return err
+	}
+
+	// Runs orphaned by a restart stop counting as in progress after an hour
+	var running int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM remediations
+		WHERE instance_id = $1 AND status = $2 AND started_at > NOW() - INTERVAL '1 hour'`,
+		r.InstanceID, StatusRunning,
+	).Scan(&running); err != nil {
+		return err
+	}
+	if running > 0 {
+		return ErrBusy
+	}
+
+	action := Actions[r.Action]
+	var recent int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM remediations
+		WHERE instance_id = $1 AND action = $2 AND status <> $3 AND started_at > $4`,
+		r.InstanceID, r.Action, StatusRateLimited, time.Now().Add(-action.Window),
+	).Scan(&recent); err != nil {
+		return err
+	}
+
+	r.Status = StatusRunning
+	if recent >= action.Limit {
+		now := time.Now()
+		r.Status, r.FinishedAt = StatusRateLimited, &now
+	}
+	if err := tx.QueryRow(`
+		INSERT INTO remediations (instance_id, action, unit, trigger, status, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, started_at`,
+		r.InstanceID, r.Action, r.Unit, r.Trigger, r.Status, r.FinishedAt,
+	).Scan(&r.ID, &r.StartedAt); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if r.Status == StatusRateLimited {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+func (s *Store) Finish(r *Run) error {
+	return s.db.QueryRow(`
+		UPDATE remediations SET status = $1, output = $2, error = $3, finished_at = NOW()
+		WHERE id = $4
+		RETURNING finished_at`,
+		r.Status, r.Output, r.Error, r.ID,
+	).Scan(&r.FinishedAt)
+}
+
+// ListByInstance returns an instance's most recent runs, newest first.
+func (s *Store) ListByInstance(instanceID int64, limit int) ([]*Run, error) {
+	rows, err := s.db.Query(`
+		SELECT id, instance_id, action, unit, trigger, status, output, error, started_at, finished_at
+		FROM remediations WHERE instance_id = $1
+		ORDER BY started_at DESC LIMIT $2`,
+		instanceID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*Run
+	for rows.Next() {
+		r := &Run{}
+		if err := rows.Scan(&r.ID, &r.InstanceID, &r.Action, &r.Unit, &r.Trigger, &r.Status, &r.Output, &r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
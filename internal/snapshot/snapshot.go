@@ -0,0 +1,173 @@
+package snapshot
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	StatusDeleted   Status = "deleted" // expired and removed from EC2
+)
+
+// Snapshot is an EBS snapshot of an instance's root volume.
+type Snapshot struct {
+	ID            int64      `json:"id"`
+	InstanceID    int64      `json:"instance_id"`
+	Slug          string     `json:"slug"`
+	EC2SnapshotID string     `json:"ec2_snapshot_id"`
+	Region        string     `json:"region,omitempty"` // empty for the compute client's own
+	VolumeID      string     `json:"volume_id"`
+	SizeGB        int32      `json:"size_gb"`
+	Trigger       string     `json:"trigger"` // "scheduled", "manual" or "migration"
+	Status        Status     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// migrations add columns introduced after the snapshots table was created.
+var migrations = []string{
+	`ALTER TABLE snapshots ADD COLUMN IF NOT EXISTS region TEXT NOT NULL DEFAULT ''`,
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS snapshots (
+			id               BIGSERIAL PRIMARY KEY,
+			instance_id      BIGINT NOT NULL,
+			slug             TEXT NOT NULL,
+			ec2_snapshot_id  TEXT NOT NULL DEFAULT '',
+			volume_id        TEXT NOT NULL DEFAULT '',
+			size_gb          INTEGER NOT NULL DEFAULT 0,
+			trigger          TEXT NOT NULL,
+			status           TEXT NOT NULL,
+			error            TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			completed_at     TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS snapshots_instance_idx ON snapshots (instance_id, created_at DESC)
+	`)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, err := s.db.Exec(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Create(sn *Snapshot) error {
+	sn.Status = StatusPending
+	return s.db.QueryRow(`
+		INSERT INTO snapshots (instance_id, slug, ec2_snapshot_id, region, volume_id, size_gb, trigger, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`,
+		sn.InstanceID, sn.Slug, sn.EC2SnapshotID, sn.Region, sn.VolumeID, sn.SizeGB, sn.Trigger, sn.Status,
+	).Scan(&sn.ID, &sn.CreatedAt)
+}
+
+func (s *Store) Complete(id int64) error {
+	_, err := s.db.Exec(`UPDATE snapshots SET status = $1, completed_at = NOW() WHERE id = $2`, StatusCompleted, id)
+	return err
+}
+
+func (s *Store) Fail(id int64, reason string) error {
+	_, err := s.db.Exec(`UPDATE snapshots SET status = $1, error = $2 WHERE id = $3`, StatusFailed, reason, id)
+	return err
+}
+
+func (s *Store) MarkDeleted(id int64) error {
+	_, err := s.db.Exec(`UPDATE snapshots SET status = $1 WHERE id = $2`, StatusDeleted, id)
+	return err
+}
+
+const columns = `id, instance_id, slug, ec2_snapshot_id, region, volume_id, size_gb, trigger, status, error, created_at, completed_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Snapshot, error) {
+	sn := &Snapshot{}
+	err := row.Scan(
+		&sn.ID, &sn.InstanceID, &sn.Slug, &sn.EC2SnapshotID, &sn.Region, &sn.VolumeID, &sn.SizeGB,
+		&sn.Trigger, &sn.Status, &sn.Error, &sn.CreatedAt, &sn.CompletedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return sn, err
+}
+
+func (s *Store) query(where string, args ...any) ([]*Snapshot, error) {
+	rows, err := s.db.Query(`SELECT `+columns+` FROM snapshots WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snaps []*Snapshot
+	for rows.Next() {
+		sn, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, sn)
+	}
+	return snaps, rows.Err()
+}
+
+func (s *Store) Get(id int64) (*Snapshot, error) {
+	return scan(s.db.QueryRow(`SELECT `+columns+` FROM snapshots WHERE id = $1`, id))
+}
+
+// ListByInstance returns an instance's snapshots that still exist, newest
+// first.
+func (s *Store) ListByInstance(instanceID int64) ([]*Snapshot, error) {
+	return s.query(`instance_id = $1 AND status <> $2 ORDER BY created_at DESC`, instanceID, StatusDeleted)
+}
+
+// LastCreated returns when an instance's newest pending or completed
+// snapshot was taken, or the zero time if it has none.
+func (s *Store) LastCreated(instanceID int64) (time.Time, error) {
+	var last sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT MAX(created_at) FROM snapshots
+		WHERE instance_id = $1 AND status IN ($2, $3)`,
+		instanceID, StatusPending, StatusCompleted,
+	).Scan(&last)
+	return last.Time, err
+}
+
+// ListPending returns snapshots EC2 hasn't finished copying yet.
+func (s *Store) ListPending() ([]*Snapshot, error) {
+	return s.query(`status = $1 ORDER BY created_at`, StatusPending)
+}
+
+// ListExpired returns completed snapshots taken before a time, oldest
+// first, sparing each instance's newest keep completed snapshots however
+// old they are.
+func (s *Store) ListExpired(before time.Time, keep int) ([]*Snapshot, error) {
+	return s.query(`id IN (
+			SELECT id FROM (
+				SELECT id, created_at, ROW_NUMBER() OVER (PARTITION BY instance_id ORDER BY created_at DESC, id DESC) AS newest
+				FROM snapshots WHERE status = $1
+			) ranked
+			WHERE newest > $3 AND created_at < $2
+		) ORDER BY created_at`, StatusCompleted, before, keep)
+}
@@ -0,0 +1,119 @@
+package sshca
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoginUser is the account certificates log in as. Instances accept a
+// certificate for it only if it names their own principal.
+const LoginUser = "ubuntu"
+
+// Principal is the one certificate principal an instance accepts, listed in
+// its AuthorizedPrincipalsFile, so a certificate for one hub opens no other.
+func Principal(slug string) string {
+	return "crimata-" + slug
+}
+
+type Config struct {
+	KeyFile    string        // PEM or OpenSSH private key of the CA
+	DefaultTTL time.Duration // used when a request gives none
+	MaxTTL     time.Duration
+}
+
+// CA signs short-lived user certificates that instances accept through
+// sshd's TrustedUserCAKeys, so operators never need an instance's own key.
+type CA struct {
+	signer ssh.Signer
+	cfg    Config
+}
+
+func Load(cfg Config) (*CA, error) {
+	pemBytes, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read ca key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse ca key: %w", err)
+	}
+	if cfg.DefaultTTL <= 0 {
+		cfg.DefaultTTL = 15 * time.Minute
+	}
+	if cfg.MaxTTL < cfg.DefaultTTL {
+		cfg.MaxTTL = cfg.DefaultTTL
+	}
+	return &CA{signer: signer, cfg: cfg}, nil
+}
+
+// PublicKey returns the CA key in authorized_keys format, for instances to
+// trust.
+func (c *CA) PublicKey() string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(c.signer.PublicKey())))
+}
+
+// Fingerprint identifies the CA key, to tell which instances trust it.
+func (c *CA) Fingerprint() string {
+	return ssh.FingerprintSHA256(c.signer.PublicKey())
+}
+
+// Request is a certificate to issue for one instance. A zero TTL falls back
+// to the configured default; a longer TTL is capped at the maximum.
+type Request struct {
+	PublicKey string // operator key in authorized_keys format
+	KeyID     string // shown in the instance's sshd log
+	Slug      string // the instance the certificate is valid for
+	TTL       time.Duration
+}
+
+// Sign issues a user certificate for the request's public key.
+func (c *CA) Sign(req Request) (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+
+	if req.Slug == "" {
+		return nil, errors.New("certificate needs an instance slug")
+	}
+
+	ttl := req.TTL
+	if ttl <= 0 {
+		ttl = c.cfg.DefaultTTL
+	}
+	ttl = min(ttl, c.cfg.MaxTTL)
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+
+	// Backdate a little to allow for clock skew on the instance
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           req.KeyID,
+		ValidPrincipals: []string{Principal(req.Slug)},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(ttl).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty":             "",
+				"permit-port-forwarding": "",
+			},
+		},
+	}
+	if err := cert.SignCert(rand.Reader, c.signer); err != nil {
+		return nil, fmt.Errorf("sign certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,248 @@
+package upgrade
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusHalted    Status = "halted" // stopped early; remaining results stay pending
+)
+
+type ResultStatus string
+
+const (
+	ResultPending   ResultStatus = "pending"
+	ResultSucceeded ResultStatus = "succeeded"
+	ResultFailed    ResultStatus = "failed"
+	ResultSkipped   ResultStatus = "skipped" // no longer active when its batch ran
+)
+
+// Upgrade is a fleet-wide run of a script across active instances, in
+// batches. Batch 0 is the canary.
+type Upgrade struct {
+	ID             int64      `json:"id"`
+	Description    string     `json:"description"`
+	Script         string     `json:"script"`
+	BatchSize      int        `json:"batch_size"`
+	CanarySize     int        `json:"canary_size"`
+	MaxFailureRate float64    `json:"max_failure_rate"` // halt once failed/attempted exceeds this
+	Status         Status     `json:"status"`
+	HaltReason     string     `json:"halt_reason,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	Results        []*Result  `json:"results,omitempty"`
+}
+
+// Result is one instance's outcome within an upgrade.
+type Result struct {
+	InstanceID int64        `json:"instance_id"`
+	Slug       string       `json:"slug"`
+	Batch      int          `json:"batch"`
+	Status     ResultStatus `json:"status"`
+	Output     string       `json:"output,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) Migrate() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS upgrades (
+			id                BIGSERIAL PRIMARY KEY,
+			description       TEXT NOT NULL DEFAULT '',
+			script            TEXT NOT NULL,
+			batch_size        INTEGER NOT NULL,
+			canary_size       INTEGER NOT NULL,
+			max_failure_rate  DOUBLE PRECISION NOT NULL,
+			status            TEXT NOT NULL DEFAULT 'running',
+			halt_reason       TEXT NOT NULL DEFAULT '',
+			created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			finished_at       TIMESTAMPTZ
+		);
+		CREATE TABLE IF NOT EXISTS upgrade_results (
+			upgrade_id   BIGINT NOT NULL REFERENCES upgrades(id) ON DELETE CASCADE,
+			instance_id  BIGINT NOT NULL,
+			slug         TEXT NOT NULL,
+			batch        INTEGER NOT NULL,
+			status       TEXT NOT NULL DEFAULT 'pending',
+			output       TEXT NOT NULL DEFAULT '',
+			error        TEXT NOT NULL DEFAULT '',
+			started_at   TIMESTAMPTZ,
+			finished_at  TIMESTAMPTZ,
+			PRIMARY KEY (upgrade_id, instance_id)
+		)
+	`)
+	return err
+}
+
+// Create records an upgrade and its planned results in one transaction.
+func (s *Store) Create(u *Upgrade) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO upgrades (description, script, batch_size, canary_size, max_failure_rate, status)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`,
+		u.Description, u.Script, u.BatchSize, u.CanarySize, u.MaxFailureRate, StatusRunning,
+	).Scan(&u.ID, &u.CreatedAt)
+	if err != nil {
+		return err
+	}
+	u.Status = StatusRunning
+
+	for _, r := range u.Results {
+		if _, err := tx.Exec(`
+			INSERT INTO upgrade_results (upgrade_id, instance_id, slug, batch, status)
+			VALUES ($1, $2, $3, $4, $5)`,
+			u.ID, r.InstanceID, r.Slug, r.Batch, ResultPending,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) StartResult(upgradeID, instanceID int64) error {
+	_, err := s.db.Exec(
+		`UPDATE upgrade_results SET started_at = NOW() WHERE upgrade_id = $1 AND instance_id = $2`,
+		upgradeID, instanceID,
+	)
+	return err
+}
+
+func (s *Store) FinishResult(upgradeID int64, r *Result) error {
+	_, err := s.db.Exec(`
+		UPDATE upgrade_results SET status = $1, output = $2, error = $3, finished_at = NOW()
+		WHERE upgrade_id = $4 AND instance_id = $5`,
+		r.Status, r.Output, r.Error, upgradeID, r.InstanceID,
+	)
+	return err
+}
+
+// Finish marks an upgrade completed, or halted when reason is non-empty.
+func (s *Store) Finish(id int64, reason string) error {
+	status := StatusCompleted
+	if reason != "" {
+		status = StatusHalted
+	}
+	_, err := s.db.Exec(
+		`UPDATE upgrades SET status = $1, halt_reason = $2, finished_at = NOW() WHERE id = $3`,
+		status, reason, id,
+	)
+	return err
+}
+
+// Interrupt halts a running upgrade that lost its orchestrator, e.g. to a
+// restart. Results started but never finished are marked failed, since the
+// script may have partly run; pending ones stay pending.
+func (s *Store) Interrupt(id int64, reason string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		UPDATE upgrade_results SET status = $1, error = $2, finished_at = NOW()
+		WHERE upgrade_id = $3 AND started_at IS NOT NULL AND finished_at IS NULL`,
+		ResultFailed, reason, id,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		`UPDATE upgrades SET status = $1, halt_reason = $2, finished_at = NOW() WHERE id = $3 AND status = $4`,
+		StatusHalted, reason, id, StatusRunning,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const columns = `id, description, script, batch_size, canary_size, max_failure_rate, status, halt_reason, created_at, finished_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (*Upgrade, error) {
+	u := &Upgrade{}
+	err := row.Scan(
+		&u.ID, &u.Description, &u.Script, &u.BatchSize, &u.CanarySize,
+		&u.MaxFailureRate, &u.Status, &u.HaltReason, &u.CreatedAt, &u.FinishedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return u, err
+}
+
+// Get returns an upgrade with its results ordered by batch.
+func (s *Store) Get(id int64) (*Upgrade, error) {
+	u, err := scan(s.db.QueryRow(`SELECT `+columns+` FROM upgrades WHERE id = $1`, id))
+	if err != nil || u == nil {
+		return u, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT instance_id, slug, batch, status, output, error, started_at, finished_at
+		FROM upgrade_results WHERE upgrade_id = $1
+		ORDER BY batch, slug`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := &Result{}
+		if err := rows.Scan(&r.InstanceID, &r.Slug, &r.Batch, &r.Status, &r.Output, &r.Error, &r.StartedAt, &r.FinishedAt); err != nil {
+			return nil, err
+		}
+		u.Results = append(u.Results, r)
+	}
+	return u, rows.Err()
+}
+
+// List returns upgrades newest first, without results.
+func (s *Store) List() ([]*Upgrade, error) {
+	return s.list(`SELECT ` + columns + ` FROM upgrades ORDER BY created_at DESC`)
+}
+
+// ListRunning returns upgrades still marked running, without results.
+func (s *Store) ListRunning() ([]*Upgrade, error) {
+	return s.list(`SELECT `+columns+` FROM upgrades WHERE status = $1 ORDER BY created_at`, StatusRunning)
+}
+
+func (s *Store) list(query string, args ...any) ([]*Upgrade, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var upgrades []*Upgrade
+	for rows.Next() {
+		u, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		upgrades = append(upgrades, u)
+	}
+	return upgrades, rows.Err()
+}